@@ -15,7 +15,17 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/hashicorp/go-multierror"
 )
@@ -23,7 +33,540 @@ import (
 type ElasticsearchConfig struct {
 	Refresh                 RefreshOption
 	URL, Username, Password string
-	InsecureSkipVerify      bool
+	// Addresses are other nodes in the cluster, that requests are spread across along with URL
+	Addresses []string
+	// DiscoverNodes finds the other nodes in the cluster at startup, and then every DiscoverNodesInterval if it's set
+	DiscoverNodes         bool
+	DiscoverNodesInterval string
+	// CloudID is the Elastic Cloud deployment to connect to, instead of URL
+	CloudID string
+	// APIKey is a base64 encoded Elasticsearch API key, used instead of a username and password
+	APIKey string
+	// ServiceToken is sent as a bearer token, instead of a username and password
+	ServiceToken string
+	// The password, API key and service token can also be read from a file, or an environment variable, rather than set in the config file.
+	// Trailing whitespace is removed from the contents of files.
+	PasswordFile, APIKeyFile, ServiceTokenFile string
+	PasswordEnv, APIKeyEnv, ServiceTokenEnv    string
+	InsecureSkipVerify                         bool
+	// CAFile is a PEM bundle of certificate authorities that the Elasticsearch certificate is verified against,
+	// instead of the system roots
+	CAFile string
+	// CertFile and KeyFile are a PEM certificate and key presented to Elasticsearch, for clusters that require mutual TLS
+	CertFile, KeyFile string
+	// ServerName overrides the host name that the Elasticsearch certificate is verified against
+	ServerName string
+	// ConflictRetries is the number of times an update is retried after a version conflict. Defaults to 0 (no retries).
+	ConflictRetries int
+	// IndexPrefix starts the name of every index and alias, and is the mappings' _meta.type, so that deployments
+	// with different prefixes can share a cluster. It can't contain a hyphen, which separates the parts of index names.
+	// Defaults to "grafeas".
+	IndexPrefix string
+	// MappingsPath is a directory of index mappings, one JSON file per document kind, that override the mappings built
+	// into Grafeas. Document kinds without a file in the directory use the built-in mapping.
+	MappingsPath string
+	Filter       FilterConfig
+	Pagination   PaginationConfig
+	Retry        RetryConfig
+	Timeouts     TimeoutConfig
+	Startup      StartupConfig
+}
+
+const DefaultIndexPrefix = "grafeas"
+
+// indexPrefixPattern is stricter than Elasticsearch index names, so that wildcards over one prefix's indices can't
+// match another's
+var indexPrefixPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.]*$`)
+
+// Prefix returns the configured index prefix, or the default if one isn't set
+func (c *ElasticsearchConfig) Prefix() string {
+	if c.IndexPrefix == "" {
+		return DefaultIndexPrefix
+	}
+
+	return c.IndexPrefix
+}
+
+// StartupConfig controls how long to wait for Elasticsearch to become available when Grafeas starts
+type StartupConfig struct {
+	// WaitTimeout is how long to wait for the cluster to be reachable, with a yellow or green status, such as "5m".
+	// Attempts are spaced out with the retry backoff. Defaults to 5m.
+	WaitTimeout string
+}
+
+const DefaultStartupWaitTimeout = 5 * time.Minute
+
+// Timeout returns the configured wait timeout, or the default if one isn't set
+func (c *StartupConfig) Timeout() time.Duration {
+	return durationOrDefault(c.WaitTimeout, DefaultStartupWaitTimeout)
+}
+
+func (c StartupConfig) IsValid() (e error) {
+	if err := validateDuration(c.WaitTimeout); err != nil {
+		e = multierror.Append(e, fmt.Errorf("invalid startup.waitTimeout value: %s", err))
+	}
+
+	return
+}
+
+// RetryConfig controls how requests to Elasticsearch are retried when a node is unavailable or overloaded
+type RetryConfig struct {
+	// Disabled turns off retries
+	Disabled bool
+	// MaxRetries is the number of times a request is retried. Defaults to 3.
+	MaxRetries int
+	// OnStatus are the response status codes that are retried, in addition to network errors. Defaults to 429, 502, 503 and 504.
+	OnStatus []int
+	// InitialBackoff is the wait before the first retry, which doubles for every retry after that, up to MaxBackoff.
+	// Defaults to 100ms and 10s.
+	InitialBackoff, MaxBackoff string
+}
+
+// TimeoutConfig limits how long requests to Elasticsearch can take, as durations such as "30s". There are no timeouts by default.
+type TimeoutConfig struct {
+	// Connect limits establishing a connection to a node, including the TLS handshake
+	Connect string
+	// Request limits each request, except for bulk requests
+	Request string
+	// Bulk limits bulk requests, which can take longer than others
+	Bulk string
+}
+
+const (
+	DefaultMaxRetries     = 3
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+)
+
+var DefaultRetryOnStatus = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// Retries returns the configured maximum number of retries, or the default if one isn't set
+func (c *RetryConfig) Retries() int {
+	if c.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+
+	return c.MaxRetries
+}
+
+// Statuses returns the configured status codes to retry, or the defaults if they aren't set
+func (c *RetryConfig) Statuses() []int {
+	if len(c.OnStatus) == 0 {
+		return DefaultRetryOnStatus
+	}
+
+	return c.OnStatus
+}
+
+// Backoff returns how long to wait before the given retry attempt, starting from 1
+func (c *RetryConfig) Backoff(attempt int) time.Duration {
+	initialBackoff := durationOrDefault(c.InitialBackoff, DefaultInitialBackoff)
+	maxBackoff := durationOrDefault(c.MaxBackoff, DefaultMaxBackoff)
+
+	backoff := initialBackoff
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+
+	return backoff
+}
+
+func (c RetryConfig) IsValid() (e error) {
+	if c.MaxRetries < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid retry.maxRetries value: %d", c.MaxRetries))
+	}
+
+	for _, status := range c.OnStatus {
+		if status < 100 || status > 599 {
+			e = multierror.Append(e, fmt.Errorf("invalid retry.onStatus value: %d", status))
+		}
+	}
+
+	durations := map[string]string{
+		"initialBackoff": c.InitialBackoff,
+		"maxBackoff":     c.MaxBackoff,
+	}
+	for name, value := range durations {
+		if err := validateDuration(value); err != nil {
+			e = multierror.Append(e, fmt.Errorf("invalid retry.%s value: %s", name, err))
+		}
+	}
+
+	return
+}
+
+// ConnectTimeout returns the configured connect timeout, or zero if there isn't one
+func (c *TimeoutConfig) ConnectTimeout() time.Duration {
+	return durationOrDefault(c.Connect, 0)
+}
+
+// RequestTimeout returns the configured request timeout, or zero if there isn't one
+func (c *TimeoutConfig) RequestTimeout() time.Duration {
+	return durationOrDefault(c.Request, 0)
+}
+
+// BulkTimeout returns the configured bulk request timeout, or zero if there isn't one
+func (c *TimeoutConfig) BulkTimeout() time.Duration {
+	return durationOrDefault(c.Bulk, 0)
+}
+
+func (c TimeoutConfig) IsValid() (e error) {
+	durations := map[string]string{
+		"connect": c.Connect,
+		"request": c.Request,
+		"bulk":    c.Bulk,
+	}
+	for name, value := range durations {
+		if err := validateDuration(value); err != nil {
+			e = multierror.Append(e, fmt.Errorf("invalid timeouts.%s value: %s", name, err))
+		}
+	}
+
+	return
+}
+
+// durationOrDefault parses a duration that has already been validated, and returns the default if it isn't set
+func durationOrDefault(value string, defaultDuration time.Duration) time.Duration {
+	if value == "" {
+		return defaultDuration
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultDuration
+	}
+
+	return duration
+}
+
+// validateDuration checks that an optional duration can be parsed, and isn't negative
+func validateDuration(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	if duration < 0 {
+		return fmt.Errorf("%s is negative", value)
+	}
+
+	return nil
+}
+
+// PaginationConfig controls how List operations are paginated
+type PaginationConfig struct {
+	// TokenKey is the secret used to sign page tokens, so that they can't be modified by clients.
+	// Every instance sharing a cluster should use the same key. If it isn't set, a random key is generated at startup,
+	// and page tokens can only be used with the instance that issued them.
+	TokenKey string
+	// Keepalive is how long Elasticsearch keeps the point in time for a listing open between pages
+	Keepalive KeepaliveConfig
+	// MaxOpenPits is the maximum number of points in time this instance can have open at once. Defaults to 100.
+	MaxOpenPits int
+	// DefaultPageSize is used when a List request doesn't specify a page size. Defaults to 100.
+	DefaultPageSize int
+	// MaxPageSize is the largest page a List request can return, larger page sizes are reduced to it. Defaults to 1000.
+	MaxPageSize int
+}
+
+// KeepaliveConfig holds a keepalive for each document kind, in Elasticsearch time units such as "5m". Each defaults to 5m.
+type KeepaliveConfig struct {
+	Projects, Occurrences, Notes string
+}
+
+const (
+	DefaultKeepalive   = "5m"
+	DefaultMaxOpenPits = 100
+	DefaultPageSize    = 100
+	DefaultMaxPageSize = 1000
+)
+
+// OpenPits returns the configured maximum number of open points in time, or the default if one isn't set
+func (c *PaginationConfig) OpenPits() int {
+	if c.MaxOpenPits == 0 {
+		return DefaultMaxOpenPits
+	}
+
+	return c.MaxOpenPits
+}
+
+// DefaultSize returns the configured default page size, or the default if one isn't set
+func (c *PaginationConfig) DefaultSize() int {
+	if c.DefaultPageSize == 0 {
+		return DefaultPageSize
+	}
+
+	return c.DefaultPageSize
+}
+
+// MaxSize returns the configured maximum page size, or the default if one isn't set
+func (c *PaginationConfig) MaxSize() int {
+	if c.MaxPageSize == 0 {
+		return DefaultMaxPageSize
+	}
+
+	return c.MaxPageSize
+}
+
+var keepalivePattern = regexp.MustCompile(`^(\d+)(d|h|m|s|ms|micros|nanos)$`)
+
+var keepaliveUnits = map[string]time.Duration{
+	"d":      24 * time.Hour,
+	"h":      time.Hour,
+	"m":      time.Minute,
+	"s":      time.Second,
+	"ms":     time.Millisecond,
+	"micros": time.Microsecond,
+	"nanos":  time.Nanosecond,
+}
+
+// ParseKeepalive converts a keepalive in Elasticsearch time units into a duration
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.x/common-options.html#time-units
+func ParseKeepalive(keepalive string) (time.Duration, error) {
+	match := keepalivePattern.FindStringSubmatch(keepalive)
+	if match == nil {
+		return 0, fmt.Errorf("invalid keepalive %q, expected a number followed by one of d, h, m, s, ms, micros, nanos", keepalive)
+	}
+
+	value, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(value) * keepaliveUnits[match[2]], nil
+}
+
+// FilterConfig limits the Elasticsearch queries that filter expressions are translated into
+type FilterConfig struct {
+	// MaxRegexLength is the longest pattern accepted by matches(). Defaults to 1000, the Elasticsearch index.max_regex_length default.
+	MaxRegexLength int
+	// MaxDeterminizedStates limits the complexity of regexp queries. Defaults to 10000, the Elasticsearch default.
+	MaxDeterminizedStates int
+	// MaxDepth is the deepest a filter expression can be nested. Defaults to 32.
+	MaxDepth int
+	// MaxClauses is the maximum number of comparisons and functions in a filter, counting each value in an `in` list. Defaults to 1024, the Elasticsearch indices.query.bool.max_clause_count default.
+	MaxClauses int
+	// MaxWildcardTerms is the maximum number of contains(), endsWith(), and matches() calls in a filter. Defaults to 10.
+	MaxWildcardTerms int
+	// DisallowLeadingWildcards rejects filters that need a query with a leading wildcard, which has to scan every term in the index
+	DisallowLeadingWildcards bool
+	// ValidateSchema checks filters against the fields and types of the Grafeas messages stored in each kind of document
+	ValidateSchema bool
+}
+
+const (
+	DefaultMaxRegexLength        = 1000
+	DefaultMaxDeterminizedStates = 10000
+	DefaultMaxDepth              = 32
+	DefaultMaxClauses            = 1024
+	DefaultMaxWildcardTerms      = 10
+)
+
+// RegexLength returns the configured maximum regex length, or the default if one isn't set
+func (c *FilterConfig) RegexLength() int {
+	if c.MaxRegexLength == 0 {
+		return DefaultMaxRegexLength
+	}
+
+	return c.MaxRegexLength
+}
+
+// DeterminizedStates returns the configured maximum number of regexp states, or the default if one isn't set
+func (c *FilterConfig) DeterminizedStates() int {
+	if c.MaxDeterminizedStates == 0 {
+		return DefaultMaxDeterminizedStates
+	}
+
+	return c.MaxDeterminizedStates
+}
+
+// Depth returns the configured maximum filter depth, or the default if one isn't set
+func (c *FilterConfig) Depth() int {
+	if c.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+
+	return c.MaxDepth
+}
+
+// Clauses returns the configured maximum number of filter clauses, or the default if one isn't set
+func (c *FilterConfig) Clauses() int {
+	if c.MaxClauses == 0 {
+		return DefaultMaxClauses
+	}
+
+	return c.MaxClauses
+}
+
+// WildcardTerms returns the configured maximum number of wildcard terms, or the default if one isn't set
+func (c *FilterConfig) WildcardTerms() int {
+	if c.MaxWildcardTerms == 0 {
+		return DefaultMaxWildcardTerms
+	}
+
+	return c.MaxWildcardTerms
+}
+
+func (c FilterConfig) IsValid() (e error) {
+	if c.MaxRegexLength < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid filter.maxRegexLength value: %d", c.MaxRegexLength))
+	}
+
+	if c.MaxDeterminizedStates < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid filter.maxDeterminizedStates value: %d", c.MaxDeterminizedStates))
+	}
+
+	if c.MaxDepth < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid filter.maxDepth value: %d", c.MaxDepth))
+	}
+
+	if c.MaxClauses < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid filter.maxClauses value: %d", c.MaxClauses))
+	}
+
+	if c.MaxWildcardTerms < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid filter.maxWildcardTerms value: %d", c.MaxWildcardTerms))
+	}
+
+	return
+}
+
+// Credentials are used to authenticate with Elasticsearch, after they've been read from files or environment variables
+type Credentials struct {
+	Username, Password, APIKey, ServiceToken string
+}
+
+// Credentials returns the configured credentials, reading them from files or environment variables when needed
+func (c *ElasticsearchConfig) Credentials() (*Credentials, error) {
+	password, err := readSecret("password", c.Password, c.PasswordFile, c.PasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, err := readSecret("apiKey", c.APIKey, c.APIKeyFile, c.APIKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceToken, err := readSecret("serviceToken", c.ServiceToken, c.ServiceTokenFile, c.ServiceTokenEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		Username:     c.Username,
+		Password:     password,
+		APIKey:       apiKey,
+		ServiceToken: serviceToken,
+	}, nil
+}
+
+// TLSConfig verifies Elasticsearch against the configured CA bundle and presents a client certificate, when they're set
+func (c *ElasticsearchConfig) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		caCerts, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", c.CAFile)
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("certFile and keyFile must be set together")
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// readSecret returns a secret that's set in the config, or reads it from a file or environment variable.
+// At most one of these is expected to be set.
+func readSecret(name, value, file, env string) (string, error) {
+	switch {
+	case file != "":
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading %s from file: %s", name, err)
+		}
+
+		return strings.TrimRightFunc(string(contents), unicode.IsSpace), nil
+	case env != "":
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("%s environment variable %s is not set", name, env)
+		}
+
+		return secret, nil
+	}
+
+	return value, nil
+}
+
+// minTokenKeyLength is the shortest page token key that's accepted, to make signatures hard to forge
+const minTokenKeyLength = 32
+
+func (c PaginationConfig) IsValid() (e error) {
+	if c.TokenKey != "" && len(c.TokenKey) < minTokenKeyLength {
+		e = multierror.Append(e, fmt.Errorf("pagination.tokenKey must be at least %d characters", minTokenKeyLength))
+	}
+
+	keepalives := map[string]string{
+		"projects":    c.Keepalive.Projects,
+		"occurrences": c.Keepalive.Occurrences,
+		"notes":       c.Keepalive.Notes,
+	}
+	for documentKind, keepalive := range keepalives {
+		if keepalive == "" {
+			continue
+		}
+
+		if _, err := ParseKeepalive(keepalive); err != nil {
+			e = multierror.Append(e, fmt.Errorf("invalid pagination.keepalive.%s value: %s", documentKind, err))
+		}
+	}
+
+	if c.MaxOpenPits < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid pagination.maxOpenPits value: %d", c.MaxOpenPits))
+	}
+
+	if c.DefaultPageSize < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid pagination.defaultPageSize value: %d", c.DefaultPageSize))
+	}
+
+	if c.MaxPageSize < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid pagination.maxPageSize value: %d", c.MaxPageSize))
+	}
+
+	if c.DefaultSize() > c.MaxSize() {
+		e = multierror.Append(e, fmt.Errorf("pagination.defaultPageSize (%d) can't be larger than pagination.maxPageSize (%d)", c.DefaultSize(), c.MaxSize()))
+	}
+
+	return
 }
 
 func (c ElasticsearchConfig) IsValid() (e error) {
@@ -34,9 +577,90 @@ func (c ElasticsearchConfig) IsValid() (e error) {
 		e = multierror.Append(e, fmt.Errorf("invalid refresh value: %s", c.Refresh))
 	}
 
+	if (c.URL != "" || len(c.Addresses) > 0) && c.CloudID != "" {
+		e = multierror.Append(e, errors.New("url and addresses can't be used with cloudId"))
+	}
+
+	if err := validateDuration(c.DiscoverNodesInterval); err != nil {
+		e = multierror.Append(e, fmt.Errorf("invalid discoverNodesInterval value: %s", err))
+	}
+
+	secrets := map[string][]string{
+		"password":     {c.Password, c.PasswordFile, c.PasswordEnv},
+		"apiKey":       {c.APIKey, c.APIKeyFile, c.APIKeyEnv},
+		"serviceToken": {c.ServiceToken, c.ServiceTokenFile, c.ServiceTokenEnv},
+	}
+	for name, sources := range secrets {
+		if countSet(sources...) > 1 {
+			e = multierror.Append(e, fmt.Errorf("only one of %[1]s, %[1]sFile and %[1]sEnv can be set", name))
+		}
+	}
+
+	authMethods := 0
+	for _, sources := range [][]string{
+		{c.Username, c.Password, c.PasswordFile, c.PasswordEnv},
+		secrets["apiKey"],
+		secrets["serviceToken"],
+	} {
+		if countSet(sources...) > 0 {
+			authMethods++
+		}
+	}
+	if authMethods > 1 {
+		e = multierror.Append(e, errors.New("only one of username and password, apiKey, or serviceToken authentication can be used"))
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		e = multierror.Append(e, errors.New("certFile and keyFile must be set together"))
+	}
+
+	if c.InsecureSkipVerify && c.CAFile != "" {
+		e = multierror.Append(e, errors.New("caFile can't be used with insecureSkipVerify, which skips verifying the certificate"))
+	}
+
+	if c.ConflictRetries < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid conflictRetries value: %d", c.ConflictRetries))
+	}
+
+	if c.IndexPrefix != "" && !indexPrefixPattern.MatchString(c.IndexPrefix) {
+		e = multierror.Append(e, fmt.Errorf("invalid indexPrefix value %q, only lowercase letters, numbers, underscores and periods are allowed", c.IndexPrefix))
+	}
+
+	if err := c.Filter.IsValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	if err := c.Pagination.IsValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	if err := c.Retry.IsValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	if err := c.Timeouts.IsValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	if err := c.Startup.IsValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
 	return
 }
 
+// countSet returns the number of values that aren't empty
+func countSet(values ...string) int {
+	count := 0
+	for _, value := range values {
+		if value != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
 // RefreshOption is based on https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-refresh.html
 type RefreshOption string
 
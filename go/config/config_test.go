@@ -15,6 +15,17 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -46,6 +57,726 @@ var _ = Describe("ElasticsearchConfig", func() {
 			URL:     fake.URL(),
 			Refresh: "somethingInvalid",
 		}, true),
+		Entry("cloud id", ElasticsearchConfig{
+			CloudID: fake.LetterN(20),
+			Refresh: RefreshTrue,
+		}, false),
+		Entry("multiple addresses", ElasticsearchConfig{
+			URL:                   fake.URL(),
+			Addresses:             []string{fake.URL(), fake.URL()},
+			DiscoverNodes:         true,
+			DiscoverNodesInterval: "5m",
+			Refresh:               RefreshTrue,
+		}, false),
+		Entry("invalid discover nodes interval", ElasticsearchConfig{
+			URL:                   fake.URL(),
+			DiscoverNodes:         true,
+			DiscoverNodesInterval: "5 minutes",
+			Refresh:               RefreshTrue,
+		}, true),
+		Entry("addresses and cloud id", ElasticsearchConfig{
+			Addresses: []string{fake.URL()},
+			CloudID:   fake.LetterN(20),
+			Refresh:   RefreshTrue,
+		}, true),
+		Entry("retries", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retry: RetryConfig{
+				MaxRetries:     5,
+				OnStatus:       []int{429, 503},
+				InitialBackoff: "50ms",
+				MaxBackoff:     "5s",
+			},
+		}, false),
+		Entry("negative max retries", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retry: RetryConfig{
+				MaxRetries: -1,
+			},
+		}, true),
+		Entry("invalid retry status", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retry: RetryConfig{
+				OnStatus: []int{5030},
+			},
+		}, true),
+		Entry("invalid backoff", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retry: RetryConfig{
+				InitialBackoff: "-1s",
+			},
+		}, true),
+		Entry("timeouts", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Timeouts: TimeoutConfig{
+				Connect: "5s",
+				Request: "30s",
+				Bulk:    "2m",
+			},
+		}, false),
+		Entry("invalid timeout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Timeouts: TimeoutConfig{
+				Bulk: "2 minutes",
+			},
+		}, true),
+		Entry("startup wait timeout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Startup: StartupConfig{
+				WaitTimeout: "10m",
+			},
+		}, false),
+		Entry("invalid startup wait timeout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Startup: StartupConfig{
+				WaitTimeout: "forever",
+			},
+		}, true),
+		Entry("url and cloud id", ElasticsearchConfig{
+			URL:     fake.URL(),
+			CloudID: fake.LetterN(20),
+			Refresh: RefreshTrue,
+		}, true),
+		Entry("basic auth", ElasticsearchConfig{
+			URL:      fake.URL(),
+			Refresh:  RefreshTrue,
+			Username: fake.Username(),
+			Password: fake.LetterN(10),
+		}, false),
+		Entry("basic auth, password from a file", ElasticsearchConfig{
+			URL:          fake.URL(),
+			Refresh:      RefreshTrue,
+			Username:     fake.Username(),
+			PasswordFile: fake.LetterN(10),
+		}, false),
+		Entry("basic auth, password and password file", ElasticsearchConfig{
+			URL:          fake.URL(),
+			Refresh:      RefreshTrue,
+			Username:     fake.Username(),
+			Password:     fake.LetterN(10),
+			PasswordFile: fake.LetterN(10),
+		}, true),
+		Entry("api key", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			APIKey:  fake.LetterN(10),
+		}, false),
+		Entry("api key from an environment variable", ElasticsearchConfig{
+			URL:       fake.URL(),
+			Refresh:   RefreshTrue,
+			APIKeyEnv: fake.LetterN(10),
+		}, false),
+		Entry("api key and api key file", ElasticsearchConfig{
+			URL:        fake.URL(),
+			Refresh:    RefreshTrue,
+			APIKey:     fake.LetterN(10),
+			APIKeyFile: fake.LetterN(10),
+		}, true),
+		Entry("service token", ElasticsearchConfig{
+			URL:          fake.URL(),
+			Refresh:      RefreshTrue,
+			ServiceToken: fake.LetterN(10),
+		}, false),
+		Entry("service token env and file", ElasticsearchConfig{
+			URL:              fake.URL(),
+			Refresh:          RefreshTrue,
+			ServiceTokenEnv:  fake.LetterN(10),
+			ServiceTokenFile: fake.LetterN(10),
+		}, true),
+		Entry("basic auth and api key", ElasticsearchConfig{
+			URL:      fake.URL(),
+			Refresh:  RefreshTrue,
+			Username: fake.Username(),
+			Password: fake.LetterN(10),
+			APIKey:   fake.LetterN(10),
+		}, true),
+		Entry("api key and service token", ElasticsearchConfig{
+			URL:             fake.URL(),
+			Refresh:         RefreshTrue,
+			APIKeyFile:      fake.LetterN(10),
+			ServiceTokenEnv: fake.LetterN(10),
+		}, true),
+		Entry("username and service token", ElasticsearchConfig{
+			URL:          fake.URL(),
+			Refresh:      RefreshTrue,
+			Username:     fake.Username(),
+			ServiceToken: fake.LetterN(10),
+		}, true),
+		Entry("tls", ElasticsearchConfig{
+			URL:        fake.URL(),
+			Refresh:    RefreshTrue,
+			CAFile:     fake.LetterN(10),
+			CertFile:   fake.LetterN(10),
+			KeyFile:    fake.LetterN(10),
+			ServerName: fake.DomainName(),
+		}, false),
+		Entry("tls, only a CA", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			CAFile:  fake.LetterN(10),
+		}, false),
+		Entry("tls, cert without a key", ElasticsearchConfig{
+			URL:      fake.URL(),
+			Refresh:  RefreshTrue,
+			CertFile: fake.LetterN(10),
+		}, true),
+		Entry("tls, key without a cert", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			KeyFile: fake.LetterN(10),
+		}, true),
+		Entry("tls, CA with insecureSkipVerify", ElasticsearchConfig{
+			URL:                fake.URL(),
+			Refresh:            RefreshTrue,
+			CAFile:             fake.LetterN(10),
+			InsecureSkipVerify: true,
+		}, true),
+		Entry("tls, insecureSkipVerify", ElasticsearchConfig{
+			URL:                fake.URL(),
+			Refresh:            RefreshTrue,
+			InsecureSkipVerify: true,
+		}, false),
+		Entry("conflict retries", ElasticsearchConfig{
+			URL:             fake.URL(),
+			Refresh:         RefreshTrue,
+			ConflictRetries: 3,
+		}, false),
+		Entry("negative conflict retries", ElasticsearchConfig{
+			URL:             fake.URL(),
+			Refresh:         RefreshTrue,
+			ConflictRetries: -1,
+		}, true),
+		Entry("index prefix and mappings path", ElasticsearchConfig{
+			URL:          fake.URL(),
+			Refresh:      RefreshTrue,
+			IndexPrefix:  "grafeas_staging",
+			MappingsPath: fake.LetterN(10),
+		}, false),
+		Entry("index prefix with a hyphen", ElasticsearchConfig{
+			URL:         fake.URL(),
+			Refresh:     RefreshTrue,
+			IndexPrefix: "grafeas-staging",
+		}, true),
+		Entry("uppercase index prefix", ElasticsearchConfig{
+			URL:         fake.URL(),
+			Refresh:     RefreshTrue,
+			IndexPrefix: "Grafeas",
+		}, true),
+		Entry("index prefix starting with an underscore", ElasticsearchConfig{
+			URL:         fake.URL(),
+			Refresh:     RefreshTrue,
+			IndexPrefix: "_grafeas",
+		}, true),
+		Entry("filter limits", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxRegexLength:           100,
+				MaxDeterminizedStates:    1000,
+				MaxDepth:                 10,
+				MaxClauses:               100,
+				MaxWildcardTerms:         1,
+				DisallowLeadingWildcards: true,
+				ValidateSchema:           true,
+			},
+		}, false),
+		Entry("negative max regex length", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxRegexLength: -1,
+			},
+		}, true),
+		Entry("negative max determinized states", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxDeterminizedStates: -1,
+			},
+		}, true),
+		Entry("negative max depth", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxDepth: -1,
+			},
+		}, true),
+		Entry("negative max clauses", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxClauses: -1,
+			},
+		}, true),
+		Entry("negative max wildcard terms", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxWildcardTerms: -1,
+			},
+		}, true),
+		Entry("no page token key", ElasticsearchConfig{
+			URL:        fake.URL(),
+			Refresh:    RefreshTrue,
+			Pagination: PaginationConfig{},
+		}, false),
+		Entry("page token key", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				TokenKey: fake.LetterN(32),
+			},
+		}, false),
+		Entry("short page token key", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				TokenKey: fake.LetterN(31),
+			},
+		}, true),
+		Entry("pit limits", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				Keepalive: KeepaliveConfig{
+					Projects:    "30s",
+					Occurrences: "10m",
+					Notes:       "1h",
+				},
+				MaxOpenPits: 10,
+			},
+		}, false),
+		Entry("invalid keepalive", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				Keepalive: KeepaliveConfig{
+					Occurrences: "10 minutes",
+				},
+			},
+		}, true),
+		Entry("page sizes", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				DefaultPageSize: 50,
+				MaxPageSize:     500,
+			},
+		}, false),
+		Entry("negative default page size", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				DefaultPageSize: -1,
+			},
+		}, true),
+		Entry("negative max page size", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				MaxPageSize: -1,
+			},
+		}, true),
+		Entry("default page size larger than the max", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				DefaultPageSize: 200,
+				MaxPageSize:     100,
+			},
+		}, true),
+		Entry("default page size larger than the default max", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				DefaultPageSize: DefaultMaxPageSize + 1,
+			},
+		}, true),
+		Entry("negative max open pits", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Pagination: PaginationConfig{
+				MaxOpenPits: -1,
+			},
+		}, true),
+	)
+
+	Context("FilterConfig", func() {
+		It("should use the defaults when limits are not set", func() {
+			c := &FilterConfig{}
+
+			Expect(c.RegexLength()).To(Equal(DefaultMaxRegexLength))
+			Expect(c.DeterminizedStates()).To(Equal(DefaultMaxDeterminizedStates))
+			Expect(c.Depth()).To(Equal(DefaultMaxDepth))
+			Expect(c.Clauses()).To(Equal(DefaultMaxClauses))
+			Expect(c.WildcardTerms()).To(Equal(DefaultMaxWildcardTerms))
+		})
+
+		It("should use the configured limits", func() {
+			c := &FilterConfig{
+				MaxRegexLength:        fake.Number(1, 100),
+				MaxDeterminizedStates: fake.Number(1, 100),
+				MaxDepth:              fake.Number(1, 100),
+				MaxClauses:            fake.Number(1, 100),
+				MaxWildcardTerms:      fake.Number(1, 100),
+			}
+
+			Expect(c.RegexLength()).To(Equal(c.MaxRegexLength))
+			Expect(c.DeterminizedStates()).To(Equal(c.MaxDeterminizedStates))
+			Expect(c.Depth()).To(Equal(c.MaxDepth))
+			Expect(c.Clauses()).To(Equal(c.MaxClauses))
+			Expect(c.WildcardTerms()).To(Equal(c.MaxWildcardTerms))
+		})
+	})
+
+	Context("PaginationConfig", func() {
+		It("should use the defaults when limits are not set", func() {
+			c := &PaginationConfig{}
+
+			Expect(c.OpenPits()).To(Equal(DefaultMaxOpenPits))
+			Expect(c.DefaultSize()).To(Equal(DefaultPageSize))
+			Expect(c.MaxSize()).To(Equal(DefaultMaxPageSize))
+		})
+
+		It("should use the configured limits", func() {
+			c := &PaginationConfig{
+				MaxOpenPits:     fake.Number(1, 100),
+				DefaultPageSize: fake.Number(1, 100),
+				MaxPageSize:     fake.Number(100, 200),
+			}
+
+			Expect(c.OpenPits()).To(Equal(c.MaxOpenPits))
+			Expect(c.DefaultSize()).To(Equal(c.DefaultPageSize))
+			Expect(c.MaxSize()).To(Equal(c.MaxPageSize))
+		})
+	})
+
+	Context("RetryConfig", func() {
+		It("should use the defaults when settings are not set", func() {
+			c := &RetryConfig{}
+
+			Expect(c.Retries()).To(Equal(DefaultMaxRetries))
+			Expect(c.Statuses()).To(ConsistOf(429, 502, 503, 504))
+			Expect(c.Backoff(1)).To(Equal(DefaultInitialBackoff))
+		})
+
+		It("should use the configured settings", func() {
+			c := &RetryConfig{
+				MaxRetries: fake.Number(1, 10),
+				OnStatus:   []int{fake.Number(500, 599)},
+			}
+
+			Expect(c.Retries()).To(Equal(c.MaxRetries))
+			Expect(c.Statuses()).To(Equal(c.OnStatus))
+		})
+
+		DescribeTable("exponential backoff", func(attempt int, expected time.Duration) {
+			c := &RetryConfig{
+				InitialBackoff: "100ms",
+				MaxBackoff:     "1s",
+			}
+
+			Expect(c.Backoff(attempt)).To(Equal(expected))
+		},
+			Entry("first retry", 1, 100*time.Millisecond),
+			Entry("second retry", 2, 200*time.Millisecond),
+			Entry("third retry", 3, 400*time.Millisecond),
+			Entry("capped at the max backoff", 5, time.Second),
+			Entry("many retries", 100, time.Second),
+		)
+	})
+
+	Context("TimeoutConfig", func() {
+		It("should not have timeouts by default", func() {
+			c := &TimeoutConfig{}
+
+			Expect(c.ConnectTimeout()).To(BeZero())
+			Expect(c.RequestTimeout()).To(BeZero())
+			Expect(c.BulkTimeout()).To(BeZero())
+		})
+
+		It("should use the configured timeouts", func() {
+			c := &TimeoutConfig{
+				Connect: "5s",
+				Request: "30s",
+				Bulk:    "2m",
+			}
+
+			Expect(c.ConnectTimeout()).To(Equal(5 * time.Second))
+			Expect(c.RequestTimeout()).To(Equal(30 * time.Second))
+			Expect(c.BulkTimeout()).To(Equal(2 * time.Minute))
+		})
+	})
+
+	Context("StartupConfig", func() {
+		It("should use the default wait timeout when it isn't set", func() {
+			c := &StartupConfig{}
+
+			Expect(c.Timeout()).To(Equal(DefaultStartupWaitTimeout))
+		})
+
+		It("should use the configured wait timeout", func() {
+			c := &StartupConfig{
+				WaitTimeout: "30s",
+			}
+
+			Expect(c.Timeout()).To(Equal(30 * time.Second))
+		})
+	})
+
+	Context("Prefix", func() {
+		It("should use the default index prefix when it isn't set", func() {
+			c := &ElasticsearchConfig{}
+
+			Expect(c.Prefix()).To(Equal(DefaultIndexPrefix))
+		})
+
+		It("should use the configured index prefix", func() {
+			c := &ElasticsearchConfig{
+				IndexPrefix: "grafeas_qa",
+			}
+
+			Expect(c.Prefix()).To(Equal("grafeas_qa"))
+		})
+	})
+
+	Context("Credentials", func() {
+		var (
+			c        *ElasticsearchConfig
+			secret   string
+			tempFile *os.File
+		)
+
+		BeforeEach(func() {
+			secret = fake.LetterN(20)
+
+			var err error
+			tempFile, err = os.CreateTemp("", "secret")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tempFile.WriteString(secret + "\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tempFile.Close()).To(Succeed())
+
+			c = &ElasticsearchConfig{
+				Username: fake.Username(),
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.Remove(tempFile.Name())).To(Succeed())
+		})
+
+		It("should return the credentials set in the config", func() {
+			c.Password = secret
+
+			credentials, err := c.Credentials()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials).To(Equal(&Credentials{
+				Username: c.Username,
+				Password: secret,
+			}))
+		})
+
+		It("should read credentials from files, without the trailing newline", func() {
+			c.Username = ""
+			c.APIKeyFile = tempFile.Name()
+
+			credentials, err := c.Credentials()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials.APIKey).To(Equal(secret))
+		})
+
+		It("should read credentials from environment variables", func() {
+			env := "TEST_SERVICE_TOKEN_" + fake.LetterN(10)
+			Expect(os.Setenv(env, secret)).To(Succeed())
+			defer os.Unsetenv(env)
+
+			c.Username = ""
+			c.ServiceTokenEnv = env
+
+			credentials, err := c.Credentials()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials.ServiceToken).To(Equal(secret))
+		})
+
+		It("should return an error when the file doesn't exist", func() {
+			c.PasswordFile = tempFile.Name() + fake.LetterN(10)
+
+			_, err := c.Credentials()
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error when the environment variable isn't set", func() {
+			c.PasswordEnv = "TEST_PASSWORD_" + fake.LetterN(10)
+
+			_, err := c.Credentials()
+
+			Expect(err).To(MatchError(ContainSubstring(c.PasswordEnv)))
+		})
+	})
+
+	Context("TLSConfig", func() {
+		var (
+			c                         *ElasticsearchConfig
+			dir                       string
+			caFile, certFile, keyFile string
+			certificate               *x509.Certificate
+		)
+
+		writeFile := func(name string, contents []byte) string {
+			path := filepath.Join(dir, name)
+			Expect(os.WriteFile(path, contents, 0600)).To(Succeed())
+
+			return path
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "tls")
+			Expect(err).ToNot(HaveOccurred())
+
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			template := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: fake.DomainName()},
+				NotBefore:             time.Now(),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			Expect(err).ToNot(HaveOccurred())
+			certificate, err = x509.ParseCertificate(der)
+			Expect(err).ToNot(HaveOccurred())
+			keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+
+			certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			caFile = writeFile("ca.pem", certPem)
+			certFile = writeFile("cert.pem", certPem)
+			keyFile = writeFile("key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}))
+
+			c = &ElasticsearchConfig{
+				ServerName: fake.DomainName(),
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("should use the system roots when no TLS files are set", func() {
+			c.InsecureSkipVerify = true
+
+			tlsConfig, err := c.TLSConfig()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tlsConfig.RootCAs).To(BeNil())
+			Expect(tlsConfig.Certificates).To(BeEmpty())
+			Expect(tlsConfig.InsecureSkipVerify).To(BeTrue())
+			Expect(tlsConfig.ServerName).To(Equal(c.ServerName))
+		})
+
+		It("should verify against the CA bundle and present the client certificate", func() {
+			c.CAFile = caFile
+			c.CertFile = certFile
+			c.KeyFile = keyFile
+
+			tlsConfig, err := c.TLSConfig()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tlsConfig.RootCAs.Subjects()).To(Equal([][]byte{certificate.RawSubject}))
+			Expect(tlsConfig.Certificates).To(HaveLen(1))
+			Expect(tlsConfig.Certificates[0].Certificate).To(Equal([][]byte{certificate.Raw}))
+		})
+
+		It("should return an error when the CA file doesn't exist", func() {
+			c.CAFile = filepath.Join(dir, fake.LetterN(10))
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError(ContainSubstring("error reading CA file")))
+		})
+
+		It("should return an error when the CA file has no PEM certificates", func() {
+			c.CAFile = writeFile("empty.pem", []byte(fake.LetterN(50)))
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError(ContainSubstring("no PEM certificates found")))
+		})
+
+		It("should return an error when the CA file has a malformed certificate", func() {
+			c.CAFile = writeFile("bad.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(fake.LetterN(50))}))
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError(ContainSubstring("no PEM certificates found")))
+		})
+
+		It("should return an error when the CA file only has a key", func() {
+			c.CAFile = keyFile
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError(ContainSubstring("no PEM certificates found")))
+		})
+
+		It("should return an error when the client certificate doesn't match the key", func() {
+			c.CertFile = keyFile
+			c.KeyFile = certFile
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError(ContainSubstring("error loading client certificate")))
+		})
+
+		It("should return an error when only the certificate is set", func() {
+			c.CertFile = certFile
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError("certFile and keyFile must be set together"))
+		})
+
+		It("should return an error when only the key is set", func() {
+			c.KeyFile = keyFile
+
+			_, err := c.TLSConfig()
+
+			Expect(err).To(MatchError("certFile and keyFile must be set together"))
+		})
+	})
+
+	DescribeTable("ParseKeepalive", func(keepalive string, expected time.Duration, shouldErr bool) {
+		actual, err := ParseKeepalive(keepalive)
+
+		if shouldErr {
+			Expect(err).To(HaveOccurred())
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actual).To(Equal(expected))
+		}
+	},
+		Entry("days", "2d", 48*time.Hour, false),
+		Entry("minutes", "5m", 5*time.Minute, false),
+		Entry("milliseconds", "500ms", 500*time.Millisecond, false),
+		Entry("no unit", "5", time.Duration(0), true),
+		Entry("unknown unit", "5y", time.Duration(0), true),
+		Entry("fractional", "1.5h", time.Duration(0), true),
 	)
 
 	When("setting the InsecureSkipVerify boolean value", func() {
@@ -34,16 +34,16 @@ func (m *MockFilterer) EXPECT() *MockFiltererMockRecorder {
 }
 
 // ParseExpression mocks base method
-func (m *MockFilterer) ParseExpression(arg0 string) (*filtering.Query, error) {
+func (m *MockFilterer) ParseExpression(arg0, arg1 string) (*filtering.Query, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ParseExpression", arg0)
+	ret := m.ctrl.Call(m, "ParseExpression", arg0, arg1)
 	ret0, _ := ret[0].(*filtering.Query)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ParseExpression indicates an expected call of ParseExpression
-func (mr *MockFiltererMockRecorder) ParseExpression(arg0 interface{}) *gomock.Call {
+func (mr *MockFiltererMockRecorder) ParseExpression(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseExpression", reflect.TypeOf((*MockFilterer)(nil).ParseExpression), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseExpression", reflect.TypeOf((*MockFilterer)(nil).ParseExpression), arg0, arg1)
 }
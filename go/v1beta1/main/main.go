@@ -15,14 +15,13 @@
 package main
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-
-	"github.com/rode/es-index-manager/indexmanager"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/grafeas/grafeas/go/v1beta1/server"
@@ -42,14 +41,14 @@ func main() {
 	}
 
 	registerStorageTypeProvider := storage.ElasticsearchStorageTypeProviderCreator(func(c *config.ElasticsearchConfig) (*storage.ElasticsearchStorage, error) {
-		esClient, err := createESClient(logger, c.URL, c.Username, c.Password, c.InsecureSkipVerify)
+		esClient, err := createESClient(logger, c)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to Elasticsearch")
+			return nil, fmt.Errorf("failed to connect to Elasticsearch: %s", err)
 		}
 
-		indexManager := indexmanager.NewIndexManager(logger.Named("IndexManager"), esClient, &indexmanager.Config{MappingsPath: "mappings", IndexPrefix: "grafeas"})
+		indexManager := storage.NewIndexManager(logger.Named("IndexManager"), esClient, c)
 
-		return storage.NewElasticsearchStorage(logger.Named("ElasticsearchStore"), esutil.NewClient(logger, esClient), filtering.NewFilterer(), c, indexManager), nil
+		return storage.NewElasticsearchStorage(logger.Named("ElasticsearchStore"), esutil.NewClientWithConfig(logger, esClient, c), filtering.NewFiltererWithConfig(&c.Filter, storage.FilterSchemas()), c, indexManager), nil
 	}, logger)
 
 	err = grafeasStorage.RegisterStorageTypeProvider("elasticsearch", registerStorageTypeProvider)
@@ -63,33 +62,69 @@ func main() {
 	}
 }
 
-func createESClient(logger *zap.Logger, elasticsearchEndpoint, username, password string, insecureSkipVerify bool) (*elasticsearch.Client, error) {
-	c, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{
-			elasticsearchEndpoint,
-		},
-		Username: username,
-		Password: password,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
-		},
-	})
+func createESClient(logger *zap.Logger, esConfig *config.ElasticsearchConfig) (*elasticsearch.Client, error) {
+	tlsConfig, err := esConfig.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
 
+	credentials, err := esConfig.Credentials()
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := c.Info()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if connectTimeout := esConfig.Timeouts.ConnectTimeout(); connectTimeout != 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = connectTimeout
+	}
+
+	clientConfig := elasticsearch.Config{
+		CloudID:              esConfig.CloudID,
+		Username:             credentials.Username,
+		Password:             credentials.Password,
+		APIKey:               credentials.APIKey,
+		DiscoverNodesOnStart: esConfig.DiscoverNodes,
+		DisableRetry:         esConfig.Retry.Disabled,
+		MaxRetries:           esConfig.Retry.Retries(),
+		RetryOnStatus:        esConfig.Retry.Statuses(),
+		RetryBackoff:         esConfig.Retry.Backoff,
+		Transport:            transport,
+	}
+
+	if esConfig.URL != "" {
+		clientConfig.Addresses = append(clientConfig.Addresses, esConfig.URL)
+	}
+	clientConfig.Addresses = append(clientConfig.Addresses, esConfig.Addresses...)
+
+	if esConfig.DiscoverNodes && esConfig.DiscoverNodesInterval != "" {
+		clientConfig.DiscoverNodesInterval, err = time.ParseDuration(esConfig.DiscoverNodesInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if credentials.ServiceToken != "" {
+		clientConfig.Header = http.Header{
+			"Authorization": []string{"Bearer " + credentials.ServiceToken},
+		}
+	}
+
+	c, err := elasticsearch.NewClient(clientConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	var r map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+	version, err := esutil.WaitForCluster(context.Background(), logger, c, esConfig)
+	if err != nil {
 		return nil, err
 	}
 
-	logger.Debug("Successful Elasticsearch connection", zap.String("ES Server version", r["version"].(map[string]interface{})["number"].(string)))
+	logger.Debug("Successful Elasticsearch connection", zap.String("ES Server version", version.String()))
 
 	return c, nil
 }
@@ -16,11 +16,18 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/google/uuid"
+	"github.com/grafeas/grafeas/go/name"
 	"github.com/rode/es-index-manager/indexmanager"
 	"github.com/rode/grafeas-elasticsearch/go/config"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
@@ -30,8 +37,11 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 
+	"github.com/grafeas/grafeas/proto/v1beta1/common_go_proto"
 	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/package_go_proto"
 	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/vulnerability_go_proto"
 
 	"github.com/golang/protobuf/protoc-gen-go/generator"
 	fieldmask_utils "github.com/mennanov/fieldmask-utils"
@@ -43,8 +53,23 @@ const (
 	occurrencesDocumentKind = "occurrences"
 	notesDocumentKind       = "notes"
 	sortField               = "createTime"
+	scoreSortField          = "_score"
+	tiebreakerSortField     = "name"
 )
 
+// aggregation names and limits used for the vulnerability occurrences summary
+const (
+	resourcesAggregationName  = "resources"
+	severitiesAggregationName = "severities"
+	fixableAggregationName    = "fixable"
+	// vulnerabilitySummaryPageSize is the number of resources aggregated in each request. Each resource has up to
+	// a dozen sub-buckets, which keeps every page well under the search.max_buckets limit.
+	vulnerabilitySummaryPageSize = 1000
+)
+
+// Highlights holds the fragments of each descriptive field that matched the search() function in a filter, keyed by field name
+type Highlights map[string][]string
+
 type ElasticsearchStorage struct {
 	client       esutil.Client
 	config       *config.ElasticsearchConfig
@@ -159,7 +184,7 @@ func (es *ElasticsearchStorage) ListProjects(ctx context.Context, filter string,
 	var projects []*prpb.Project
 	log := es.logger.Named("ListProjects")
 
-	res, nextPageToken, err := es.genericList(ctx, log, es.projectsAlias(), filter, false, pageToken, int32(pageSize))
+	res, nextPageToken, err := es.genericList(ctx, log, es.projectsAlias(), projectDocumentKind, nil, filter, false, pageToken, int32(pageSize))
 	if err != nil {
 		return nil, "", err
 	}
@@ -202,6 +227,10 @@ func (es *ElasticsearchStorage) DeleteProject(ctx context.Context, projectId str
 		Search:  search,
 		Refresh: es.config.Refresh.String(),
 	})
+	if errors.Is(err, esutil.ErrNotFound) {
+		log.Debug("project not found")
+		return status.Errorf(codes.NotFound, "project %s not found", projectName)
+	}
 	if err != nil {
 		return createError(log, "error deleting project in elasticsearch", err)
 	}
@@ -249,15 +278,25 @@ func (es *ElasticsearchStorage) GetOccurrence(ctx context.Context, projectId, oc
 // ListOccurrences returns up to pageSize number of occurrences for this project beginning
 // at pageToken, or from start if pageToken is the empty string.
 func (es *ElasticsearchStorage) ListOccurrences(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	occurrences, _, nextPageToken, err := es.ListOccurrencesWithHighlights(ctx, projectId, filter, pageToken, pageSize)
+
+	return occurrences, nextPageToken, err
+}
+
+// ListOccurrencesWithHighlights is ListOccurrences, but also returns the highlights for each occurrence when the filter uses search()
+func (es *ElasticsearchStorage) ListOccurrencesWithHighlights(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, []Highlights, string, error) {
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	log := es.logger.Named("ListOccurrences").With(zap.String("project", projectName))
 
-	res, nextPageToken, err := es.genericList(ctx, log, es.occurrencesAlias(projectId), filter, true, pageToken, pageSize)
+	res, nextPageToken, err := es.genericList(ctx, log, es.occurrencesAlias(projectId), occurrencesDocumentKind, nil, filter, true, pageToken, pageSize)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, "", err
 	}
 
-	var occurrences []*pb.Occurrence
+	var (
+		occurrences []*pb.Occurrence
+		highlights  []Highlights
+	)
 	for _, hit := range res.Hits {
 		hitLogger := log.With(zap.String("occurrence raw", string(hit.Source)))
 
@@ -265,15 +304,16 @@ func (es *ElasticsearchStorage) ListOccurrences(ctx context.Context, projectId,
 		err := protojson.Unmarshal(hit.Source, proto.MessageV2(occurrence))
 		if err != nil {
 			log.Error("failed to convert _doc to occurrence", zap.Error(err))
-			return nil, "", createError(hitLogger, "error converting _doc to occurrence", err)
+			return nil, nil, "", createError(hitLogger, "error converting _doc to occurrence", err)
 		}
 
 		hitLogger.Debug("occurrence hit", zap.Any("occurrence", occurrence))
 
 		occurrences = append(occurrences, occurrence)
+		highlights = append(highlights, hitHighlights(hit))
 	}
 
-	return occurrences, nextPageToken, nil
+	return occurrences, highlights, nextPageToken, nil
 }
 
 // CreateOccurrence adds the specified occurrence to Elasticsearch
@@ -385,34 +425,25 @@ func (es *ElasticsearchStorage) UpdateOccurrence(ctx context.Context, projectId,
 		},
 	}
 
-	occurrence := &pb.Occurrence{}
-
-	targetDocumentID, err := es.genericGet(ctx, log, search, es.occurrencesAlias(projectId), occurrence)
-
-	if err != nil {
-		return nil, err
-	}
-
+	// the mask can be nil, and belongs to the caller, so the update time is added to a copy of its paths
+	paths := append([]string{}, mask.GetPaths()...)
 	if o.UpdateTime == nil {
-		mask.Paths = append(mask.Paths, "UpdateTime")
+		paths = append(paths, "UpdateTime")
 		o.UpdateTime = ptypes.TimestampNow()
 	}
 
-	m, err := fieldmask_utils.MaskFromPaths(mask.Paths, generator.CamelCase)
+	m, err := fieldmask_utils.MaskFromPaths(paths, generator.CamelCase)
 	if err != nil {
 		log.Info("errors while mapping masks", zap.Any("errors", err))
-		return occurrence, err
+		return nil, err
 	}
-	fieldmask_utils.StructToStruct(m, o, occurrence)
 
-	_, err = es.client.Update(ctx, &esutil.UpdateRequest{
-		Index:      es.occurrencesAlias(projectId),
-		DocumentId: targetDocumentID,
-		Message:    proto.MessageV2(occurrence),
-		Refresh:    es.config.Refresh.String(),
+	occurrence := &pb.Occurrence{}
+	err = es.genericUpdate(ctx, log, search, es.occurrencesAlias(projectId), occurrence, func() error {
+		return fieldmask_utils.StructToStruct(m, o, occurrence)
 	})
 	if err != nil {
-		return nil, createError(log, "error updating occurrence in elasticsearch", err)
+		return nil, err
 	}
 
 	return occurrence, nil
@@ -438,6 +469,10 @@ func (es *ElasticsearchStorage) DeleteOccurrence(ctx context.Context, projectId,
 		Search:  search,
 		Refresh: es.config.Refresh.String(),
 	})
+	if errors.Is(err, esutil.ErrNotFound) {
+		log.Debug("occurrence not found")
+		return status.Errorf(codes.NotFound, "occurrence %s not found", occurrenceName)
+	}
 	if err != nil {
 		return createError(log, "error deleting occurrence in elasticsearch", err)
 	}
@@ -470,15 +505,25 @@ func (es *ElasticsearchStorage) GetNote(ctx context.Context, projectId, noteId s
 // ListNotes returns up to pageSize number of notes for this project (pID) beginning
 // at pageToken (or from start if pageToken is the empty string).
 func (es *ElasticsearchStorage) ListNotes(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Note, string, error) {
+	notes, _, nextPageToken, err := es.ListNotesWithHighlights(ctx, projectId, filter, pageToken, pageSize)
+
+	return notes, nextPageToken, err
+}
+
+// ListNotesWithHighlights is ListNotes, but also returns the highlights for each note when the filter uses search()
+func (es *ElasticsearchStorage) ListNotesWithHighlights(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Note, []Highlights, string, error) {
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	log := es.logger.Named("ListNotes").With(zap.String("project", projectName))
 
-	res, nextPageToken, err := es.genericList(ctx, log, es.notesAlias(projectId), filter, true, pageToken, pageSize)
+	res, nextPageToken, err := es.genericList(ctx, log, es.notesAlias(projectId), notesDocumentKind, nil, filter, true, pageToken, pageSize)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, "", err
 	}
 
-	var notes []*pb.Note
+	var (
+		notes      []*pb.Note
+		highlights []Highlights
+	)
 	for _, hit := range res.Hits {
 		hitLogger := log.With(zap.String("note raw", string(hit.Source)))
 
@@ -486,15 +531,16 @@ func (es *ElasticsearchStorage) ListNotes(ctx context.Context, projectId, filter
 		err := protojson.Unmarshal(hit.Source, proto.MessageV2(note))
 		if err != nil {
 			log.Error("failed to convert _doc to note", zap.Error(err))
-			return nil, "", createError(hitLogger, "error converting _doc to note", err)
+			return nil, nil, "", createError(hitLogger, "error converting _doc to note", err)
 		}
 
 		hitLogger.Debug("note hit", zap.Any("note", note))
 
 		notes = append(notes, note)
+		highlights = append(highlights, hitHighlights(hit))
 	}
 
-	return notes, nextPageToken, nil
+	return notes, highlights, nextPageToken, nil
 }
 
 // CreateNote adds the specified note
@@ -649,9 +695,41 @@ func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId,
 	return createdNotes, nil
 }
 
-// UpdateNote updates the existing note with the given pID and nID
-func (es *ElasticsearchStorage) UpdateNote(ctx context.Context, pID, nID string, n *pb.Note, mask *fieldmaskpb.FieldMask) (*pb.Note, error) {
-	return nil, nil
+// UpdateNote updates the existing note with the given projectId and noteId
+func (es *ElasticsearchStorage) UpdateNote(ctx context.Context, projectId, noteId string, n *pb.Note, mask *fieldmaskpb.FieldMask) (*pb.Note, error) {
+	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
+	log := es.logger.Named("UpdateNote").With(zap.String("note", noteName))
+
+	search := &esutil.EsSearch{
+		Query: &filtering.Query{
+			Term: &filtering.Term{
+				"name": noteName,
+			},
+		},
+	}
+
+	// the mask can be nil, and belongs to the caller, so the update time is added to a copy of its paths
+	paths := append([]string{}, mask.GetPaths()...)
+	if n.UpdateTime == nil {
+		paths = append(paths, "UpdateTime")
+		n.UpdateTime = ptypes.TimestampNow()
+	}
+
+	m, err := fieldmask_utils.MaskFromPaths(paths, generator.CamelCase)
+	if err != nil {
+		log.Info("errors while mapping masks", zap.Any("errors", err))
+		return nil, err
+	}
+
+	note := &pb.Note{}
+	err = es.genericUpdate(ctx, log, search, es.notesAlias(projectId), note, func() error {
+		return fieldmask_utils.StructToStruct(m, n, note)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return note, nil
 }
 
 // DeleteNote deletes the note with the given pID and nID
@@ -674,6 +752,10 @@ func (es *ElasticsearchStorage) DeleteNote(ctx context.Context, projectId, noteI
 		Search:  search,
 		Refresh: es.config.Refresh.String(),
 	})
+	if errors.Is(err, esutil.ErrNotFound) {
+		log.Debug("note not found")
+		return status.Errorf(codes.NotFound, "note %s not found", noteName)
+	}
 	if err != nil {
 		return createError(log, "error deleting note in elasticsearch", err)
 	}
@@ -681,62 +763,329 @@ func (es *ElasticsearchStorage) DeleteNote(ctx context.Context, projectId, noteI
 	return nil
 }
 
-// GetOccurrenceNote gets the note for the specified occurrence from PostgreSQL.
-func (es *ElasticsearchStorage) GetOccurrenceNote(ctx context.Context, pID, oID string) (*pb.Note, error) {
-	return nil, nil
+// GetOccurrenceNote gets the note for the specified occurrence.
+// The note is looked up using the occurrence's noteName, so it may belong to a different project than the occurrence.
+func (es *ElasticsearchStorage) GetOccurrenceNote(ctx context.Context, projectId, occurrenceId string) (*pb.Note, error) {
+	occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", projectId, occurrenceId)
+	log := es.logger.Named("GetOccurrenceNote").With(zap.String("occurrence", occurrenceName))
+
+	occurrence, err := es.GetOccurrence(ctx, projectId, occurrenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	noteProjectId, noteId, err := name.ParseNote(occurrence.NoteName)
+	if err != nil {
+		return nil, createError(log, "error parsing note name of occurrence", err, zap.String("noteName", occurrence.NoteName))
+	}
+	log = log.With(zap.String("note", occurrence.NoteName))
+
+	// the note's project may not exist anymore, in which case its alias is gone too
+	exists, err := es.doesProjectExist(ctx, log, noteProjectId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		log.Debug("note project does not exist")
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("project with ID %s does not exist", noteProjectId))
+	}
+
+	return es.GetNote(ctx, noteProjectId, noteId)
 }
 
-// ListNoteOccurrences is...
-func (es *ElasticsearchStorage) ListNoteOccurrences(ctx context.Context, projectID, nID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
-	return []*pb.Occurrence{}, "", nil
+// ListNoteOccurrences returns up to pageSize number of occurrences that reference the note with the given projectId and noteId,
+// beginning at pageToken (or from start if pageToken is the empty string).
+// Occurrences for a note may live in any project, so every project's occurrences alias is searched.
+func (es *ElasticsearchStorage) ListNoteOccurrences(ctx context.Context, projectId, noteId, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
+	log := es.logger.Named("ListNoteOccurrences").With(zap.String("note", noteName))
+
+	query := &filtering.Query{
+		Term: &filtering.Term{
+			"noteName": noteName,
+		},
+	}
+
+	// the alias pattern also matches the versioned index names, so indices that would return the same occurrences again are left out
+	duplicateIndices, err := es.duplicateOccurrencesIndices(ctx)
+	if err != nil {
+		return nil, "", createError(log, "error getting occurrences indices", err)
+	}
+	if len(duplicateIndices) > 0 {
+		query = &filtering.Query{
+			Bool: &filtering.Bool{
+				Must: &filtering.Must{query},
+				MustNot: &filtering.MustNot{
+					&filtering.Query{
+						Terms: &filtering.Terms{
+							"_index": duplicateIndices,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	res, nextPageToken, err := es.genericList(ctx, log, es.allOccurrencesAlias(), occurrencesDocumentKind, query, filter, true, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var occurrences []*pb.Occurrence
+	for _, hit := range res.Hits {
+		hitLogger := log.With(zap.String("occurrence raw", string(hit.Source)))
+
+		occurrence := &pb.Occurrence{}
+		err := protojson.Unmarshal(hit.Source, proto.MessageV2(occurrence))
+		if err != nil {
+			log.Error("failed to convert _doc to occurrence", zap.Error(err))
+			return nil, "", createError(hitLogger, "error converting _doc to occurrence", err)
+		}
+
+		hitLogger.Debug("occurrence hit", zap.Any("occurrence", occurrence))
+
+		occurrences = append(occurrences, occurrence)
+	}
+
+	return occurrences, nextPageToken, nil
 }
 
-// GetVulnerabilityOccurrencesSummary gets a summary of vulnerability occurrences from storage.
-func (es *ElasticsearchStorage) GetVulnerabilityOccurrencesSummary(ctx context.Context, projectID, filter string) (*pb.VulnerabilityOccurrencesSummary, error) {
-	return &pb.VulnerabilityOccurrencesSummary{}, nil
+// GetVulnerabilityOccurrencesSummary gets a summary of the vulnerability occurrences in the project that match the filter.
+// For each resource, there is one count per severity, along with a count of type SEVERITY_UNSPECIFIED that totals all severities.
+func (es *ElasticsearchStorage) GetVulnerabilityOccurrencesSummary(ctx context.Context, projectId, filter string) (*pb.VulnerabilityOccurrencesSummary, error) {
+	projectName := fmt.Sprintf("projects/%s", projectId)
+	log := es.logger.Named("GetVulnerabilityOccurrencesSummary").With(zap.String("project", projectName))
+
+	query, err := es.parseFilter(log, occurrencesDocumentKind, &filtering.Query{
+		Term: &filtering.Term{
+			"kind": common_go_proto.NoteKind_VULNERABILITY.String(),
+		},
+	}, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// a vulnerability is fixable when at least one of its package issues has a version that contains the fix
+	fixableAggregation := &esutil.EsAggregation{
+		Filter: &filtering.Query{
+			Term: &filtering.Term{
+				"vulnerability.packageIssue.fixedLocation.version.kind": package_go_proto.Version_NORMAL.String(),
+			},
+		},
+	}
+	summary := &pb.VulnerabilityOccurrencesSummary{}
+	size := 0
+	// resources are paged through with a composite aggregation, so that large projects aren't truncated
+	var after map[string]interface{}
+	for {
+		search := &esutil.EsSearch{
+			Query: query,
+			Size:  &size,
+			Aggregations: map[string]*esutil.EsAggregation{
+				resourcesAggregationName: {
+					Composite: &esutil.EsCompositeAggregation{
+						Size: vulnerabilitySummaryPageSize,
+						Sources: []map[string]*esutil.EsAggregation{
+							{
+								resourcesAggregationName: {
+									Terms: &esutil.EsTermsAggregation{
+										Field: "resource.uri",
+									},
+								},
+							},
+						},
+						After: after,
+					},
+					Aggregations: map[string]*esutil.EsAggregation{
+						fixableAggregationName: fixableAggregation,
+						severitiesAggregationName: {
+							Terms: &esutil.EsTermsAggregation{
+								Field: "vulnerability.severity",
+								Size:  len(vulnerability_go_proto.Severity_name),
+							},
+							Aggregations: map[string]*esutil.EsAggregation{
+								fixableAggregationName: fixableAggregation,
+							},
+						},
+					},
+				},
+			},
+		}
+
+		res, err := es.client.Search(ctx, &esutil.SearchRequest{
+			Index:  es.occurrencesAlias(projectId),
+			Search: search,
+		})
+		if err != nil {
+			return nil, createError(log, "error aggregating vulnerability occurrences in elasticsearch", err)
+		}
+
+		resources, ok := res.Aggregations[resourcesAggregationName]
+		if !ok || len(resources.Buckets) == 0 {
+			break
+		}
+
+		for _, resourceBucket := range resources.Buckets {
+			summary.Counts = append(summary.Counts, vulnerabilityResourceCounts(resourceBucket)...)
+		}
+
+		if len(resources.AfterKey) == 0 {
+			break
+		}
+		after = resources.AfterKey
+	}
+
+	return summary, nil
 }
 
-func (es *ElasticsearchStorage) genericGet(ctx context.Context, log *zap.Logger, search *esutil.EsSearch, index string, protoMessage interface{}) (string, error) {
+// vulnerabilityResourceCounts returns the total and per-severity counts for a resource bucket of the vulnerability summary
+func vulnerabilityResourceCounts(resourceBucket *esutil.EsAggregationBucket) []*pb.VulnerabilityOccurrencesSummary_FixableTotalByDigest {
+	key, _ := resourceBucket.Key.(map[string]interface{})
+	resource := &pb.Resource{
+		Uri: fmt.Sprint(key[resourcesAggregationName]),
+	}
+
+	counts := []*pb.VulnerabilityOccurrencesSummary_FixableTotalByDigest{
+		{
+			Resource:     resource,
+			Severity:     vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED,
+			FixableCount: int64(aggregationDocCount(resourceBucket.Aggregations, fixableAggregationName)),
+			TotalCount:   int64(resourceBucket.DocCount),
+		},
+	}
+
+	severities, ok := resourceBucket.Aggregations[severitiesAggregationName]
+	if !ok {
+		return counts
+	}
+
+	for _, severityBucket := range severities.Buckets {
+		severity := vulnerability_go_proto.Severity(vulnerability_go_proto.Severity_value[fmt.Sprint(severityBucket.Key)])
+		if severity == vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED {
+			continue
+		}
+
+		counts = append(counts, &pb.VulnerabilityOccurrencesSummary_FixableTotalByDigest{
+			Resource:     resource,
+			Severity:     severity,
+			FixableCount: int64(aggregationDocCount(severityBucket.Aggregations, fixableAggregationName)),
+			TotalCount:   int64(severityBucket.DocCount),
+		})
+	}
+
+	return counts
+}
+
+func (es *ElasticsearchStorage) genericGet(ctx context.Context, log *zap.Logger, search *esutil.EsSearch, index string, protoMessage interface{}) (*esutil.EsSearchResponseHit, error) {
 	res, err := es.client.Search(ctx, &esutil.SearchRequest{
 		Index:  index,
 		Search: search,
 	})
 	if err != nil {
-		return "", createError(log, "error searching elasticsearch for document", err)
+		return nil, createError(log, "error searching elasticsearch for document", err)
 	}
 
 	if res.Hits.Total.Value == 0 {
 		log.Debug("document not found", zap.Any("search", search))
-		return "", status.Error(codes.NotFound, fmt.Sprintf("%T not found", protoMessage))
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("%T not found", protoMessage))
 	}
 
-	return res.Hits.Hits[0].ID, protojson.Unmarshal(res.Hits.Hits[0].Source, proto.MessageV2(protoMessage))
+	hit := res.Hits.Hits[0]
+
+	return hit, protojson.Unmarshal(hit.Source, proto.MessageV2(protoMessage))
 }
 
-func (es *ElasticsearchStorage) genericList(ctx context.Context, log *zap.Logger, index, filter string, sort bool, pageToken string, pageSize int32) (*esutil.EsSearchResponseHits, string, error) {
-	search := &esutil.EsSearch{}
+// genericUpdate reads the document matching the search into protoMessage, applies the update, and writes it back
+// only if the document hasn't been modified in the meantime. Version conflicts are retried up to the configured
+// number of times before returning codes.Aborted.
+func (es *ElasticsearchStorage) genericUpdate(ctx context.Context, log *zap.Logger, search *esutil.EsSearch, index string, protoMessage interface{}, applyUpdate func() error) error {
+	search.SeqNoPrimaryTerm = true
+
+	for attempt := 0; ; attempt++ {
+		hit, err := es.genericGet(ctx, log, search, index, protoMessage)
+		if err != nil {
+			return err
+		}
+
+		if err := applyUpdate(); err != nil {
+			return createError(log, "error applying update to document", err)
+		}
+
+		_, err = es.client.Update(ctx, &esutil.UpdateRequest{
+			Index:         index,
+			DocumentId:    hit.ID,
+			Message:       proto.MessageV2(protoMessage),
+			Refresh:       es.config.Refresh.String(),
+			IfSeqNo:       hit.SeqNo,
+			IfPrimaryTerm: hit.PrimaryTerm,
+		})
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, esutil.ErrVersionConflict) {
+			return createError(log, "error updating document in elasticsearch", err)
+		}
+
+		if attempt >= es.config.ConflictRetries {
+			log.Info("document was modified concurrently", zap.Int("attempts", attempt+1))
+			return status.Errorf(codes.Aborted, "%T was modified concurrently, please retry", protoMessage)
+		}
+
+		log.Debug("version conflict while updating document, retrying", zap.Int("attempt", attempt+1))
+	}
+}
+
+// genericList searches the given index using the filter expression, if one is given.
+// When query is non-nil, only documents that match both the query and the filter are returned.
+func (es *ElasticsearchStorage) genericList(ctx context.Context, log *zap.Logger, index, documentKind string, query *filtering.Query, filter string, sort bool, pageToken string, pageSize int32) (*esutil.EsSearchResponseHits, string, error) {
 	if filter != "" {
 		log = log.With(zap.String("filter", filter))
-		filterQuery, err := es.filterer.ParseExpression(filter)
-		if err != nil {
-			return nil, "", createError(log, "error while parsing filter expression", err)
+	}
+
+	size, err := es.pageSize(pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query, err = es.parseFilter(log, documentKind, query, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	search := &esutil.EsSearch{
+		Query: query,
+	}
+
+	fullTextSearch := query.IsFullTextSearch()
+	if fullTextSearch {
+		search.Highlight = &esutil.EsHighlight{
+			Fields: map[string]struct{}{},
+		}
+		for _, field := range filtering.SearchFields {
+			search.Highlight.Fields[field] = struct{}{}
 		}
+	}
 
-		search.Query = filterQuery
+	// the most relevant results come first, and ties are broken by the usual sort
+	if fullTextSearch {
+		search.Sort = append(search.Sort, esutil.EsSortField{Field: scoreSortField, Order: esutil.EsSortOrderDescending})
 	}
 
 	if sort {
-		search.Sort = map[string]esutil.EsSortOrder{
-			sortField: esutil.EsSortOrderDescending,
-		}
+		search.Sort = append(search.Sort, esutil.EsSortField{Field: sortField, Order: esutil.EsSortOrderDescending})
 	}
 
+	// names are unique, so they give every document a stable position for paginating with search_after
+	search.Sort = append(search.Sort, esutil.EsSortField{Field: tiebreakerSortField, Order: esutil.EsSortOrderAscending})
+
 	res, err := es.client.Search(ctx, &esutil.SearchRequest{
 		Index:  index,
 		Search: search,
 		Pagination: &esutil.SearchPaginationOptions{
-			Size:  int(pageSize),
-			Token: pageToken,
+			Size:      size,
+			Token:     pageToken,
+			Keepalive: es.pitKeepalive(documentKind),
 		},
 	})
 	if err != nil {
@@ -746,11 +1095,148 @@ func (es *ElasticsearchStorage) genericList(ctx context.Context, log *zap.Logger
 	return res.Hits, res.NextPageToken, nil
 }
 
+// pageSize applies the configured default and maximum to the requested page size.
+// Every listing is paginated, so results beyond the first page are always reachable through the next page token.
+func (es *ElasticsearchStorage) pageSize(requested int32) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, status.Errorf(codes.InvalidArgument, "page size must not be negative, got %d", requested)
+	case requested == 0:
+		return es.config.Pagination.DefaultSize(), nil
+	case int(requested) > es.config.Pagination.MaxSize():
+		return es.config.Pagination.MaxSize(), nil
+	}
+
+	return int(requested), nil
+}
+
+// pitKeepalive returns the configured keepalive for listing the document kind, or an empty string to use the default
+func (es *ElasticsearchStorage) pitKeepalive(documentKind string) string {
+	switch documentKind {
+	case projectDocumentKind:
+		return es.config.Pagination.Keepalive.Projects
+	case occurrencesDocumentKind:
+		return es.config.Pagination.Keepalive.Occurrences
+	case notesDocumentKind:
+		return es.config.Pagination.Keepalive.Notes
+	}
+
+	return ""
+}
+
+// parseFilter converts the filter expression into a query and combines it with the given query.
+// Either may be empty, in which case the other is returned unchanged.
+func (es *ElasticsearchStorage) parseFilter(log *zap.Logger, documentKind string, query *filtering.Query, filter string) (*filtering.Query, error) {
+	if filter == "" {
+		return query, nil
+	}
+
+	filterQuery, err := es.filterer.ParseExpression(filter, documentKind)
+	if err != nil {
+		log.Debug("invalid filter expression", zap.Error(err))
+		return nil, status.Errorf(codes.InvalidArgument, "error while parsing filter expression: %s", err)
+	}
+
+	if query == nil {
+		return filterQuery, nil
+	}
+
+	return &filtering.Query{
+		Bool: &filtering.Bool{
+			Must: &filtering.Must{
+				query,
+				filterQuery,
+			},
+		},
+	}, nil
+}
+
+// hitHighlights returns the highlighted fragments of the hit, keyed by the descriptive field rather than its text multi-field
+func hitHighlights(hit *esutil.EsSearchResponseHit) Highlights {
+	if len(hit.Highlights) == 0 {
+		return nil
+	}
+
+	highlights := Highlights{}
+	for field, fragments := range hit.Highlights {
+		highlights[strings.TrimSuffix(field, filtering.SearchFieldSuffix)] = fragments
+	}
+
+	return highlights
+}
+
+// aggregationDocCount returns the document count of the named single-bucket aggregation, or zero if it's missing
+func aggregationDocCount(aggregations map[string]*esutil.EsAggregationResult, name string) int {
+	aggregation, ok := aggregations[name]
+	if !ok {
+		return 0
+	}
+
+	return aggregation.DocCount
+}
+
 // createError is a helper function that allows you to easily log an error and return a gRPC formatted error.
 func createError(log *zap.Logger, message string, err error, fields ...zap.Field) error {
-	log.Error(message, append(fields, zap.Error(err))...)
+	code := errorCode(err)
+	fields = append(fields, zap.Error(err), zap.Stringer("code", code))
+
+	// only unexpected failures are logged as errors, as the rest are caused by the request or are transient
+	if code == codes.Internal {
+		log.Error(message, fields...)
+	} else {
+		log.Warn(message, fields...)
+	}
 
-	return status.Errorf(codes.Internal, "%s: %s", message, err)
+	return status.Errorf(code, "%s: %s", message, err)
+}
+
+// errorCode maps an error returned from Elasticsearch to the closest gRPC status code
+func errorCode(err error) codes.Code {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+
+	if errors.Is(err, esutil.ErrInvalidPageToken) {
+		return codes.InvalidArgument
+	}
+
+	if errors.Is(err, esutil.ErrTooManyOpenPits) {
+		return codes.ResourceExhausted
+	}
+
+	var esErr *esutil.Error
+	if !errors.As(err, &esErr) {
+		return codes.Internal
+	}
+
+	switch {
+	case esErr.HasType(esutil.ErrorTypeIndexNotFound):
+		return codes.NotFound
+	case esErr.HasType(esutil.ErrorTypeVersionConflict):
+		// creating a document with an ID that's already in use is also reported as a version conflict
+		if strings.Contains(esErr.Reason, "document already exists") {
+			return codes.AlreadyExists
+		}
+
+		return codes.Aborted
+	case esErr.StatusCode == http.StatusTooManyRequests, esErr.HasType(esutil.ErrorTypeRejectedExecution):
+		return codes.ResourceExhausted
+	case esErr.StatusCode == http.StatusRequestTimeout,
+		esErr.StatusCode == http.StatusGatewayTimeout,
+		esErr.HasType(esutil.ErrorTypeTimeout, esutil.ErrorTypeReceiveTimeout, esutil.ErrorTypeClusterEventTimeout):
+		return codes.DeadlineExceeded
+	case esErr.HasType(
+		esutil.ErrorTypeParsing,
+		esutil.ErrorTypeXContentParse,
+		esutil.ErrorTypeJsonParse,
+		esutil.ErrorTypeMapperParsing,
+		esutil.ErrorTypeQueryShard,
+		esutil.ErrorTypeNumberFormat,
+	):
+		return codes.InvalidArgument
+	}
+
+	return codes.Internal
 }
 
 func (es *ElasticsearchStorage) doesProjectExist(ctx context.Context, log *zap.Logger, projectId string) (bool, error) {
@@ -796,3 +1282,79 @@ func (es *ElasticsearchStorage) occurrencesIndex(projectId string) string {
 func (es *ElasticsearchStorage) occurrencesAlias(projectId string) string {
 	return es.indexManager.AliasName(occurrencesDocumentKind, projectId)
 }
+
+// FilterSchemas returns the message types stored in each kind of document, so that filters can find the types of fields,
+// and be validated against them when validation is enabled
+func FilterSchemas() filtering.Schemas {
+	return filtering.Schemas{
+		projectDocumentKind:     proto.MessageV2(&prpb.Project{}).ProtoReflect().Descriptor(),
+		occurrencesDocumentKind: proto.MessageV2(&pb.Occurrence{}).ProtoReflect().Descriptor(),
+		notesDocumentKind:       proto.MessageV2(&pb.Note{}).ProtoReflect().Descriptor(),
+	}
+}
+
+// allOccurrencesAlias matches the occurrences alias of every project
+func (es *ElasticsearchStorage) allOccurrencesAlias() string {
+	return es.indexManager.AliasName(occurrencesDocumentKind, "*")
+}
+
+// duplicateOccurrencesIndices returns the indices matched by allOccurrencesAlias that hold copies of another index's occurrences.
+// Outside of migrations, the pattern matches each project's index both by name and through its alias, which Elasticsearch only searches once.
+// During a migration, the alias is on both the source index and the target index it's being reindexed into, so the newer target is left out
+// until the alias is swapped over. The source index is then left out, as it's no longer behind an alias, until it's deleted.
+func (es *ElasticsearchStorage) duplicateOccurrencesIndices(ctx context.Context) ([]interface{}, error) {
+	pattern := es.allOccurrencesAlias()
+	indices, err := es.client.GetIndices(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	duplicates := map[string]bool{}
+	// the oldest index behind each alias
+	aliasIndices := map[string]string{}
+	for _, name := range names {
+		aliased := false
+		for alias := range indices[name].Aliases {
+			if matched, _ := path.Match(pattern, alias); !matched {
+				continue
+			}
+			aliased = true
+
+			current, ok := aliasIndices[alias]
+			switch {
+			case !ok:
+				aliasIndices[alias] = name
+			case indexCreationDate(indices[name]) < indexCreationDate(indices[current]):
+				duplicates[current] = true
+				aliasIndices[alias] = name
+			default:
+				duplicates[name] = true
+			}
+		}
+
+		if !aliased {
+			duplicates[name] = true
+		}
+	}
+
+	var result []interface{}
+	for _, name := range names {
+		if duplicates[name] {
+			result = append(result, name)
+		}
+	}
+
+	return result, nil
+}
+
+func indexCreationDate(index *esutil.EsIndex) int64 {
+	creationDate, _ := strconv.ParseInt(index.Settings.Index.CreationDate, 10, 64)
+
+	return creationDate
+}
@@ -18,6 +18,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
@@ -40,7 +42,9 @@ import (
 	"github.com/grafeas/grafeas/proto/v1beta1/common_go_proto"
 	"github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
 	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/vulnerability_go_proto"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -425,11 +429,28 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
 			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
 
-			Expect(searchRequest.Search.Sort).To(BeNil())
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: tiebreakerSortField, Order: esutil.EsSortOrderAscending},
+			}))
 
 			Expect(searchRequest.Search.Query).To(BeNil())
 		})
 
+		When("a keepalive is configured for projects", func() {
+			var expectedKeepalive string
+
+			BeforeEach(func() {
+				expectedKeepalive = fmt.Sprintf("%ds", fake.Number(1, 60))
+				esConfig.Pagination.Keepalive.Projects = expectedKeepalive
+			})
+
+			It("should use the configured keepalive", func() {
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Pagination.Keepalive).To(Equal(expectedKeepalive))
+			})
+		})
+
 		It("should return the Grafeas project(s) and the next page token", func() {
 			Expect(actualErr).ToNot(HaveOccurred())
 			Expect(actualProjects).To(Equal(expectedProjects))
@@ -447,7 +468,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, "projects").
 					Return(expectedQuery, nil)
 			})
 
@@ -466,7 +487,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, "projects").
 					Return(nil, errors.New(fake.LetterN(10)))
 			})
 
@@ -479,7 +500,7 @@ var _ = Describe("elasticsearch storage", func() {
 				Expect(actualProjects).To(BeNil())
 				Expect(actualNextPageToken).To(BeEmpty())
 
-				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
 			})
 		})
 
@@ -587,6 +608,101 @@ var _ = Describe("elasticsearch storage", func() {
 		})
 	})
 
+	Context("error handling", func() {
+		DescribeTable("mapping elasticsearch errors to status codes", func(searchErr error, expectedCode codes.Code) {
+			client.SearchReturns(nil, searchErr)
+
+			_, err := elasticsearchStorage.GetProject(ctx, expectedProjectId)
+
+			assertErrorHasGrpcStatusCode(err, expectedCode)
+		},
+			Entry("invalid page token", fmt.Errorf("%w: illegal base64 data", esutil.ErrInvalidPageToken), codes.InvalidArgument),
+			Entry("too many open PITs", esutil.ErrTooManyOpenPits, codes.ResourceExhausted),
+			Entry("unknown error", errors.New("failed search"), codes.Internal),
+			Entry("unrecognized elasticsearch error", &esutil.Error{StatusCode: http.StatusInternalServerError, Type: "exception"}, codes.Internal),
+			Entry("index not found", &esutil.Error{StatusCode: http.StatusNotFound, Type: esutil.ErrorTypeIndexNotFound}, codes.NotFound),
+			Entry("version conflict", &esutil.Error{StatusCode: http.StatusConflict, Type: esutil.ErrorTypeVersionConflict}, codes.Aborted),
+			Entry("document already exists", &esutil.Error{
+				StatusCode: http.StatusConflict,
+				Type:       esutil.ErrorTypeVersionConflict,
+				Reason:     "[abc]: version conflict, document already exists (current version [1])",
+			}, codes.AlreadyExists),
+			Entry("too many requests", &esutil.Error{StatusCode: http.StatusTooManyRequests}, codes.ResourceExhausted),
+			Entry("rejected execution", &esutil.Error{StatusCode: http.StatusServiceUnavailable, Type: esutil.ErrorTypeRejectedExecution}, codes.ResourceExhausted),
+			Entry("gateway timeout", &esutil.Error{StatusCode: http.StatusGatewayTimeout}, codes.DeadlineExceeded),
+			Entry("timeout exception", &esutil.Error{StatusCode: http.StatusInternalServerError, Type: esutil.ErrorTypeTimeout}, codes.DeadlineExceeded),
+			Entry("context deadline exceeded", fmt.Errorf("search failed: %w", context.DeadlineExceeded), codes.DeadlineExceeded),
+			Entry("parsing exception", &esutil.Error{StatusCode: http.StatusBadRequest, Type: esutil.ErrorTypeParsing}, codes.InvalidArgument),
+			Entry("query shard root cause", &esutil.Error{
+				StatusCode:     http.StatusBadRequest,
+				Type:           "search_phase_execution_exception",
+				RootCauseTypes: []string{esutil.ErrorTypeQueryShard},
+			}, codes.InvalidArgument),
+		)
+	})
+
+	Context("page sizes", func() {
+		type listFunc func(pageSize int32) error
+
+		var (
+			listProjects listFunc = func(pageSize int32) error {
+				_, _, err := elasticsearchStorage.ListProjects(ctx, "", int(pageSize), "")
+				return err
+			}
+			listOccurrences listFunc = func(pageSize int32) error {
+				_, _, err := elasticsearchStorage.ListOccurrences(ctx, expectedProjectId, "", "", pageSize)
+				return err
+			}
+			listNotes listFunc = func(pageSize int32) error {
+				_, _, err := elasticsearchStorage.ListNotes(ctx, expectedProjectId, "", "", pageSize)
+				return err
+			}
+		)
+
+		BeforeEach(func() {
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{},
+			}, nil)
+		})
+
+		DescribeTable("applying the default and maximum page sizes", func(list listFunc, c config.PaginationConfig, requestedPageSize int32, expectedPageSize int) {
+			esConfig.Pagination = c
+
+			err := list(requestedPageSize)
+
+			Expect(err).ToNot(HaveOccurred())
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
+		},
+			Entry("projects, default page size", listProjects, config.PaginationConfig{}, int32(0), config.DefaultPageSize),
+			Entry("projects, configured default page size", listProjects, config.PaginationConfig{DefaultPageSize: 25}, int32(0), 25),
+			Entry("projects, requested page size", listProjects, config.PaginationConfig{}, int32(10), 10),
+			Entry("projects, page size above the maximum", listProjects, config.PaginationConfig{}, int32(config.DefaultMaxPageSize+1), config.DefaultMaxPageSize),
+			Entry("projects, page size above the configured maximum", listProjects, config.PaginationConfig{MaxPageSize: 200}, int32(500), 200),
+			Entry("occurrences, default page size", listOccurrences, config.PaginationConfig{}, int32(0), config.DefaultPageSize),
+			Entry("occurrences, configured default page size", listOccurrences, config.PaginationConfig{DefaultPageSize: 25}, int32(0), 25),
+			Entry("occurrences, requested page size", listOccurrences, config.PaginationConfig{}, int32(10), 10),
+			Entry("occurrences, page size above the maximum", listOccurrences, config.PaginationConfig{}, int32(config.DefaultMaxPageSize+1), config.DefaultMaxPageSize),
+			Entry("occurrences, page size above the configured maximum", listOccurrences, config.PaginationConfig{MaxPageSize: 200}, int32(500), 200),
+			Entry("notes, default page size", listNotes, config.PaginationConfig{}, int32(0), config.DefaultPageSize),
+			Entry("notes, configured default page size", listNotes, config.PaginationConfig{DefaultPageSize: 25}, int32(0), 25),
+			Entry("notes, requested page size", listNotes, config.PaginationConfig{}, int32(10), 10),
+			Entry("notes, page size above the maximum", listNotes, config.PaginationConfig{}, int32(config.DefaultMaxPageSize+1), config.DefaultMaxPageSize),
+			Entry("notes, page size above the configured maximum", listNotes, config.PaginationConfig{MaxPageSize: 200}, int32(500), 200),
+		)
+
+		DescribeTable("rejecting negative page sizes", func(list listFunc) {
+			err := list(-1)
+
+			assertErrorHasGrpcStatusCode(err, codes.InvalidArgument)
+			Expect(client.SearchCallCount()).To(Equal(0))
+		},
+			Entry("projects", listProjects),
+			Entry("occurrences", listOccurrences),
+			Entry("notes", listNotes),
+		)
+	})
+
 	Context("DeleteProject", func() {
 		var (
 			actualErr error
@@ -689,6 +805,20 @@ var _ = Describe("elasticsearch storage", func() {
 				Expect(indexManager.DeleteIndexCallCount()).To(Equal(0))
 			})
 		})
+
+		When("the project does not exist", func() {
+			BeforeEach(func() {
+				expectedDeleteDocumentError = esutil.ErrNotFound
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			})
+
+			It("should not attempt to delete the indices for notes / occurrences", func() {
+				Expect(indexManager.DeleteIndexCallCount()).To(Equal(0))
+			})
+		})
 	})
 
 	Context("GetOccurrence", func() {
@@ -1121,11 +1251,15 @@ var _ = Describe("elasticsearch storage", func() {
 			expectedSearchError    error
 
 			expectedUpdateError error
+			expectedSeqNo       int
+			expectedPrimaryTerm int
 		)
 
 		BeforeEach(func() {
 			expectedDocumentId = fake.LetterN(10)
 			expectedOccurrenceId = fake.LetterN(10)
+			expectedSeqNo = fake.Number(0, 1000)
+			expectedPrimaryTerm = fake.Number(1, 10)
 			expectedOccurrenceName = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
 			currentOccurrence = generateTestOccurrence("")
 			occurrencePatchData = &grafeas_go_proto.Occurrence{
@@ -1149,8 +1283,10 @@ var _ = Describe("elasticsearch storage", func() {
 					},
 					Hits: []*esutil.EsSearchResponseHit{
 						{
-							ID:     expectedDocumentId,
-							Source: occurrenceJson,
+							ID:          expectedDocumentId,
+							Source:      occurrenceJson,
+							SeqNo:       &expectedSeqNo,
+							PrimaryTerm: &expectedPrimaryTerm,
 						},
 					},
 				},
@@ -1175,6 +1311,7 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect((*searchRequest.Search.Query.Term)["name"]).To(Equal(expectedOccurrenceName))
 			Expect(searchRequest.Pagination).To(BeNil())
 			Expect(searchRequest.Search.Sort).To(BeNil())
+			Expect(searchRequest.Search.SeqNoPrimaryTerm).To(BeTrue())
 		})
 
 		It("should have sent a request to elasticsearch to update the occurrence document", func() {
@@ -1189,6 +1326,13 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect(occurrence.Resource.Uri).To(Equal("updatedvalue"))
 		})
 
+		It("should only update the occurrence if it has not changed since it was read", func() {
+			_, updateRequest := client.UpdateArgsForCall(0)
+
+			Expect(updateRequest.IfSeqNo).To(Equal(&expectedSeqNo))
+			Expect(updateRequest.IfPrimaryTerm).To(Equal(&expectedPrimaryTerm))
+		})
+
 		When(fmt.Sprintf("refresh configuration is %s", config.RefreshTrue), func() {
 			BeforeEach(func() {
 				esConfig.Refresh = config.RefreshTrue
@@ -1261,6 +1405,51 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 		})
 
+		When("the occurrence was modified concurrently", func() {
+			BeforeEach(func() {
+				expectedUpdateError = fmt.Errorf("%w: conflict", esutil.ErrVersionConflict)
+			})
+
+			It("should not retry the update", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+				Expect(client.UpdateCallCount()).To(Equal(1))
+			})
+
+			It("should return an aborted error", func() {
+				Expect(actualOccurrence).To(BeNil())
+				assertErrorHasGrpcStatusCode(actualErr, codes.Aborted)
+			})
+
+			When("conflict retries are configured", func() {
+				BeforeEach(func() {
+					esConfig.ConflictRetries = 2
+				})
+
+				It("should retry the update until the retries are exhausted", func() {
+					Expect(client.SearchCallCount()).To(Equal(3))
+					Expect(client.UpdateCallCount()).To(Equal(3))
+					assertErrorHasGrpcStatusCode(actualErr, codes.Aborted)
+				})
+
+				When("a retry succeeds", func() {
+					BeforeEach(func() {
+						client.UpdateReturnsOnCall(1, nil, nil)
+					})
+
+					It("should re-read the occurrence and update it", func() {
+						Expect(actualErr).ToNot(HaveOccurred())
+						Expect(client.SearchCallCount()).To(Equal(2))
+						Expect(client.UpdateCallCount()).To(Equal(2))
+
+						_, updateRequest := client.UpdateArgsForCall(1)
+						occurrence := proto.MessageV1(updateRequest.Message).(*grafeas_go_proto.Occurrence)
+						Expect(occurrence.Resource.Uri).To(Equal("updatedvalue"))
+						Expect(actualOccurrence.Resource.Uri).To(Equal("updatedvalue"))
+					})
+				})
+			})
+		})
+
 		When("using a badly formatted field mask", func() {
 			BeforeEach(func() {
 				fieldMask = &fieldmaskpb.FieldMask{
@@ -1362,6 +1551,16 @@ var _ = Describe("elasticsearch storage", func() {
 				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
 			})
 		})
+
+		When("the occurrence does not exist", func() {
+			BeforeEach(func() {
+				expectedDeleteError = esutil.ErrNotFound
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			})
+		})
 	})
 
 	Context("ListOccurrences", func() {
@@ -1427,11 +1626,38 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
 			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
 
-			Expect(searchRequest.Search.Sort).NotTo(BeNil())
-			Expect(searchRequest.Search.Sort[sortField]).To(Equal(esutil.EsSortOrderDescending))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+				{Field: tiebreakerSortField, Order: esutil.EsSortOrderAscending},
+			}))
 			Expect(searchRequest.Search.Query).To(BeNil())
 		})
 
+		It("should use the default keepalive", func() {
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Pagination.Keepalive).To(BeEmpty())
+		})
+
+		When("a keepalive is configured for occurrences", func() {
+			var expectedKeepalive string
+
+			BeforeEach(func() {
+				expectedKeepalive = fmt.Sprintf("%dm", fake.Number(1, 60))
+				esConfig.Pagination.Keepalive = config.KeepaliveConfig{
+					Projects:    "1s",
+					Occurrences: expectedKeepalive,
+					Notes:       "1s",
+				}
+			})
+
+			It("should use the configured keepalive", func() {
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Pagination.Keepalive).To(Equal(expectedKeepalive))
+			})
+		})
+
 		When("a valid filter is specified", func() {
 			BeforeEach(func() {
 				expectedQuery = &filtering.Query{
@@ -1443,7 +1669,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, "occurrences").
 					Return(expectedQuery, nil)
 			})
 
@@ -1462,7 +1688,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, "occurrences").
 					Return(nil, errors.New(fake.LetterN(10)))
 			})
 
@@ -1471,7 +1697,7 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 
 			It("should return an error", func() {
-				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
 				Expect(actualOccurrences).To(BeNil())
 				Expect(actualNextPageToken).To(BeEmpty())
 			})
@@ -2253,7 +2479,10 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
 			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
 
-			Expect(searchRequest.Search.Sort[sortField]).To(Equal(esutil.EsSortOrderDescending))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+				{Field: tiebreakerSortField, Order: esutil.EsSortOrderAscending},
+			}))
 
 			Expect(searchRequest.Search.Query).To(BeNil())
 		})
@@ -2275,7 +2504,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, "notes").
 					Return(expectedQuery, nil)
 			})
 
@@ -2294,7 +2523,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, "notes").
 					Return(nil, errors.New(fake.LetterN(10)))
 			})
 
@@ -2307,7 +2536,7 @@ var _ = Describe("elasticsearch storage", func() {
 				Expect(actualNotes).To(BeNil())
 				Expect(actualNextPageToken).To(BeEmpty())
 
-				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
 			})
 		})
 
@@ -2338,36 +2567,193 @@ var _ = Describe("elasticsearch storage", func() {
 		})
 	})
 
-	Context("DeleteNote", func() {
+	Context("ListNotesWithHighlights", func() {
 		var (
 			actualErr        error
-			expectedNoteId   string
-			expectedNoteName string
+			actualNotes      []*pb.Note
+			actualHighlights []Highlights
 
-			expectedDeleteError error
+			expectedNotes      []*pb.Note
+			expectedFilter     string
+			expectedQuery      *filtering.Query
+			expectedHighlights []string
+		)
+
+		BeforeEach(func() {
+			expectedNotes = generateTestNotes(2, expectedProjectId)
+			expectedFilter = fake.LetterN(10)
+			expectedQuery = &filtering.Query{
+				SimpleQueryString: &filtering.SimpleQueryString{
+					Query:  fake.LetterN(10),
+					Fields: filtering.SearchFields,
+				},
+			}
+			expectedHighlights = []string{fake.LetterN(10), fake.LetterN(10)}
+
+			var hits []*esutil.EsSearchResponseHit
+			for _, note := range expectedNotes {
+				json, err := protojson.Marshal(proto.MessageV2(note))
+				Expect(err).ToNot(HaveOccurred())
+
+				hits = append(hits, &esutil.EsSearchResponseHit{
+					Source: json,
+				})
+			}
+			hits[0].Highlights = map[string][]string{
+				"shortDescription.text": expectedHighlights,
+			}
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: len(hits),
+					},
+					Hits: hits,
+				},
+			}, nil)
+
+			filterer.
+				EXPECT().
+				ParseExpression(expectedFilter, "notes").
+				Return(expectedQuery, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualNotes, actualHighlights, _, actualErr = elasticsearchStorage.ListNotesWithHighlights(ctx, expectedProjectId, expectedFilter, "", int32(fake.Number(10, 20)))
+		})
+
+		It("should request highlights for the search fields", func() {
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Search.Highlight).ToNot(BeNil())
+			Expect(searchRequest.Search.Highlight.Fields).To(HaveLen(len(filtering.SearchFields)))
+			for _, field := range filtering.SearchFields {
+				Expect(searchRequest.Search.Highlight.Fields).To(HaveKey(field))
+			}
+		})
+
+		It("should order the results by relevance", func() {
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: scoreSortField, Order: esutil.EsSortOrderDescending},
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+				{Field: tiebreakerSortField, Order: esutil.EsSortOrderAscending},
+			}))
+		})
+
+		It("should return the highlights for each note, keyed by the descriptive field", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualNotes).To(Equal(expectedNotes))
+			Expect(actualHighlights).To(Equal([]Highlights{
+				{"shortDescription": expectedHighlights},
+				nil,
+			}))
+		})
+
+		When("the filter does not use search()", func() {
+			BeforeEach(func() {
+				expectedQuery.SimpleQueryString = nil
+				expectedQuery.Term = &filtering.Term{
+					fake.LetterN(10): fake.LetterN(10),
+				}
+			})
+
+			It("should not request highlights or order by relevance", func() {
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Highlight).To(BeNil())
+				Expect(searchRequest.Search.Sort).ToNot(ContainElement(esutil.EsSortField{Field: scoreSortField, Order: esutil.EsSortOrderDescending}))
+			})
+		})
+	})
+
+	Context("UpdateNote", func() {
+		var (
+			currentNote *pb.Note
+
+			expectedNote       *pb.Note
+			notePatchData      *pb.Note
+			expectedNoteId     string
+			expectedNoteName   string
+			expectedDocumentId string
+			fieldMask          *fieldmaskpb.FieldMask
+			actualErr          error
+			actualNote         *pb.Note
+
+			expectedSearchResponse *esutil.SearchResponse
+			expectedSearchError    error
+
+			expectedUpdateError error
 		)
 
 		BeforeEach(func() {
+			expectedDocumentId = fake.LetterN(10)
 			expectedNoteId = fake.LetterN(10)
 			expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+			currentNote = generateTestNote(expectedNoteName)
+			// the long description isn't in the mask, so it should keep its stored value
+			notePatchData = &pb.Note{
+				ShortDescription: "updatedvalue",
+				LongDescription:  fake.Phrase(),
+			}
+			fieldMask = &fieldmaskpb.FieldMask{
+				Paths: []string{"short_description"},
+			}
+			expectedNote = proto.Clone(currentNote).(*pb.Note)
+			expectedNote.ShortDescription = "updatedvalue"
 
-			expectedDeleteError = nil
+			noteJson, err := protojson.Marshal(proto.MessageV2(currentNote))
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: 1,
+					},
+					Hits: []*esutil.EsSearchResponseHit{
+						{
+							ID:     expectedDocumentId,
+							Source: noteJson,
+						},
+					},
+				},
+			}
+			expectedSearchError = nil
+			expectedUpdateError = nil
 		})
 
 		JustBeforeEach(func() {
-			client.DeleteReturns(expectedDeleteError)
+			client.SearchReturns(expectedSearchResponse, expectedSearchError)
+			client.UpdateReturns(nil, expectedUpdateError)
+			actualNote, actualErr = elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, notePatchData, fieldMask)
+		})
 
-			actualErr = elasticsearchStorage.DeleteNote(ctx, expectedProjectId, expectedNoteId)
+		It("should have sent a request to elasticsearch to retrieve the note document", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Index).To(Equal(expectedNotesAlias))
+
+			Expect((*searchRequest.Search.Query.Term)["name"]).To(Equal(expectedNoteName))
+			Expect(searchRequest.Pagination).To(BeNil())
+			Expect(searchRequest.Search.Sort).To(BeNil())
 		})
 
-		It("should have sent a request to elasticsearch to delete the note document", func() {
-			Expect(client.DeleteCallCount()).To(Equal(1))
+		It("should have sent a request to elasticsearch to update the note document", func() {
+			Expect(client.UpdateCallCount()).To(Equal(1))
 
-			_, deleteRequest := client.DeleteArgsForCall(0)
+			_, updateRequest := client.UpdateArgsForCall(0)
 
-			Expect(deleteRequest.Index).To(Equal(expectedNotesAlias))
-			Expect((*deleteRequest.Search.Query.Term)["name"]).To(Equal(expectedNoteName))
-			Expect(deleteRequest.Search.Sort).To(BeNil())
+			Expect(updateRequest.Index).To(Equal(expectedNotesAlias))
+			Expect(updateRequest.DocumentId).To(Equal(expectedDocumentId))
+
+			note := proto.MessageV1(updateRequest.Message).(*pb.Note)
+			Expect(note.ShortDescription).To(Equal("updatedvalue"))
+			Expect(note.LongDescription).To(Equal(currentNote.LongDescription))
+			Expect(note.Name).To(Equal(currentNote.Name))
+			Expect(note.CreateTime).To(Equal(currentNote.CreateTime))
 		})
 
 		When(fmt.Sprintf("refresh configuration is %s", config.RefreshTrue), func() {
@@ -2376,11 +2762,10 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 
 			It("should immediately refresh the index", func() {
-				Expect(client.DeleteCallCount()).To(Equal(1))
-
-				_, deleteRequest := client.DeleteArgsForCall(0)
+				Expect(client.UpdateCallCount()).To(Equal(1))
 
-				Expect(deleteRequest.Refresh).To(Equal("true"))
+				_, updateRequest := client.UpdateArgsForCall(0)
+				Expect(updateRequest.Refresh).To(Equal("true"))
 			})
 		})
 
@@ -2390,11 +2775,10 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 
 			It("should immediately refresh the index", func() {
-				Expect(client.DeleteCallCount()).To(Equal(1))
-
-				_, deleteRequest := client.DeleteArgsForCall(0)
+				Expect(client.UpdateCallCount()).To(Equal(1))
 
-				Expect(deleteRequest.Refresh).To(Equal("wait_for"))
+				_, updateRequest := client.UpdateArgsForCall(0)
+				Expect(updateRequest.Refresh).To(Equal("wait_for"))
 			})
 		})
 
@@ -2404,27 +2788,1051 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 
 			It("should not wait or force refresh of index", func() {
-				Expect(client.DeleteCallCount()).To(Equal(1))
-
-				_, deleteRequest := client.DeleteArgsForCall(0)
+				Expect(client.UpdateCallCount()).To(Equal(1))
 
-				Expect(deleteRequest.Refresh).To(Equal("false"))
+				_, updateRequest := client.UpdateArgsForCall(0)
+				Expect(updateRequest.Refresh).To(Equal("false"))
 			})
 		})
 
-		When("elasticsearch successfully deletes the note document", func() {
+		When("elasticsearch successfully updates the note document", func() {
 			It("should not return an error", func() {
 				Expect(actualErr).ToNot(HaveOccurred())
 			})
+
+			It("should contain the updated field", func() {
+				Expect(actualNote.ShortDescription).To(Equal(expectedNote.ShortDescription))
+			})
+
+			It("should have an updated UpdateTime field", func() {
+				Expect(actualNote.UpdateTime).ToNot(BeNil())
+				Expect(actualNote.UpdateTime).To(Equal(notePatchData.UpdateTime))
+			})
+
+			It("should only change the masked path, and keep the stored values of the other fields", func() {
+				expectedNote.UpdateTime = notePatchData.UpdateTime
+
+				Expect(proto.Equal(actualNote, expectedNote)).To(BeTrue())
+			})
+
+			It("should not modify the field mask", func() {
+				Expect(fieldMask.Paths).To(ConsistOf("short_description"))
+			})
 		})
 
-		When("deleting the note document fails", func() {
+		When("the field mask is nil", func() {
 			BeforeEach(func() {
-				expectedDeleteError = errors.New("delete failed")
+				fieldMask = nil
 			})
 
-			It("should return an error", func() {
-				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			It("should only change the UpdateTime field", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				expectedNote = proto.Clone(currentNote).(*pb.Note)
+				expectedNote.UpdateTime = notePatchData.UpdateTime
+
+				Expect(proto.Equal(actualNote, expectedNote)).To(BeTrue())
+			})
+		})
+
+		When("the note does not exist", func() {
+			BeforeEach(func() {
+				expectedSearchResponse.Hits.Total.Value = 0
+				expectedSearchResponse.Hits.Hits = []*esutil.EsSearchResponseHit{}
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			})
+
+			It("should not attempt to update the note", func() {
+				Expect(client.UpdateCallCount()).To(Equal(0))
+			})
+		})
+
+		When("the note was modified concurrently", func() {
+			BeforeEach(func() {
+				expectedUpdateError = fmt.Errorf("%w: conflict", esutil.ErrVersionConflict)
+				esConfig.ConflictRetries = 1
+			})
+
+			It("should retry the update once", func() {
+				Expect(client.SearchCallCount()).To(Equal(2))
+				Expect(client.UpdateCallCount()).To(Equal(2))
+			})
+
+			It("should return an aborted error", func() {
+				Expect(actualNote).To(BeNil())
+				assertErrorHasGrpcStatusCode(actualErr, codes.Aborted)
+			})
+		})
+
+		When("searching for the note fails", func() {
+			BeforeEach(func() {
+				expectedSearchError = errors.New("search failed")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+
+			It("should not attempt to update the note", func() {
+				Expect(client.UpdateCallCount()).To(Equal(0))
+			})
+		})
+
+		When("elasticsearch fails to update the note document", func() {
+			BeforeEach(func() {
+				expectedUpdateError = errors.New("update failed")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+
+		When("using a badly formatted field mask", func() {
+			BeforeEach(func() {
+				fieldMask = &fieldmaskpb.FieldMask{
+					Paths: []string{"short_description..bro"},
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("DeleteNote", func() {
+		var (
+			actualErr        error
+			expectedNoteId   string
+			expectedNoteName string
+
+			expectedDeleteError error
+		)
+
+		BeforeEach(func() {
+			expectedNoteId = fake.LetterN(10)
+			expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+
+			expectedDeleteError = nil
+		})
+
+		JustBeforeEach(func() {
+			client.DeleteReturns(expectedDeleteError)
+
+			actualErr = elasticsearchStorage.DeleteNote(ctx, expectedProjectId, expectedNoteId)
+		})
+
+		It("should have sent a request to elasticsearch to delete the note document", func() {
+			Expect(client.DeleteCallCount()).To(Equal(1))
+
+			_, deleteRequest := client.DeleteArgsForCall(0)
+
+			Expect(deleteRequest.Index).To(Equal(expectedNotesAlias))
+			Expect((*deleteRequest.Search.Query.Term)["name"]).To(Equal(expectedNoteName))
+			Expect(deleteRequest.Search.Sort).To(BeNil())
+		})
+
+		When(fmt.Sprintf("refresh configuration is %s", config.RefreshTrue), func() {
+			BeforeEach(func() {
+				esConfig.Refresh = config.RefreshTrue
+			})
+
+			It("should immediately refresh the index", func() {
+				Expect(client.DeleteCallCount()).To(Equal(1))
+
+				_, deleteRequest := client.DeleteArgsForCall(0)
+
+				Expect(deleteRequest.Refresh).To(Equal("true"))
+			})
+		})
+
+		When(fmt.Sprintf("refresh configuration is %s", config.RefreshWaitFor), func() {
+			BeforeEach(func() {
+				esConfig.Refresh = config.RefreshWaitFor
+			})
+
+			It("should immediately refresh the index", func() {
+				Expect(client.DeleteCallCount()).To(Equal(1))
+
+				_, deleteRequest := client.DeleteArgsForCall(0)
+
+				Expect(deleteRequest.Refresh).To(Equal("wait_for"))
+			})
+		})
+
+		When(fmt.Sprintf("refresh configuration is %s", config.RefreshFalse), func() {
+			BeforeEach(func() {
+				esConfig.Refresh = config.RefreshFalse
+			})
+
+			It("should not wait or force refresh of index", func() {
+				Expect(client.DeleteCallCount()).To(Equal(1))
+
+				_, deleteRequest := client.DeleteArgsForCall(0)
+
+				Expect(deleteRequest.Refresh).To(Equal("false"))
+			})
+		})
+
+		When("elasticsearch successfully deletes the note document", func() {
+			It("should not return an error", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+			})
+		})
+
+		When("deleting the note document fails", func() {
+			BeforeEach(func() {
+				expectedDeleteError = errors.New("delete failed")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+
+		When("the note does not exist", func() {
+			BeforeEach(func() {
+				expectedDeleteError = esutil.ErrNotFound
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			})
+		})
+	})
+
+	Context("GetOccurrenceNote", func() {
+		var (
+			actualErr  error
+			actualNote *pb.Note
+
+			expectedOccurrenceId   string
+			expectedOccurrenceName string
+			expectedNoteProjectId  string
+			expectedNoteId         string
+			expectedNoteName       string
+			expectedNote           *pb.Note
+			expectedNoteAlias      string
+
+			expectedOccurrenceSearchResponse *esutil.SearchResponse
+			expectedOccurrenceSearchError    error
+			expectedProjectSearchResponse    *esutil.SearchResponse
+			expectedProjectSearchError       error
+			expectedNoteSearchResponse       *esutil.SearchResponse
+			expectedNoteSearchError          error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrenceId = fake.LetterN(10)
+			expectedOccurrenceName = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+			expectedNoteProjectId = fake.LetterN(10)
+			expectedNoteId = fake.LetterN(10)
+			expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedNoteProjectId, expectedNoteId)
+			expectedNote = generateTestNote(expectedNoteName)
+			expectedNoteAlias = fake.LetterN(10)
+
+			indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+				switch {
+				case documentKind == projectDocumentKind:
+					return expectedProjectAlias
+				case documentKind == occurrencesDocumentKind && inner == expectedProjectId:
+					return expectedOccurrencesAlias
+				case documentKind == notesDocumentKind && inner == expectedNoteProjectId:
+					return expectedNoteAlias
+				}
+
+				return ""
+			})
+
+			occurrence := generateTestOccurrence(expectedOccurrenceName)
+			occurrence.NoteName = expectedNoteName
+			occurrenceJson, err := protojson.Marshal(proto.MessageV2(occurrence))
+			Expect(err).ToNot(HaveOccurred())
+
+			projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(expectedNoteProjectId)))
+			Expect(err).ToNot(HaveOccurred())
+
+			noteJson, err := protojson.Marshal(proto.MessageV2(expectedNote))
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedOccurrenceSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: 1,
+					},
+					Hits: []*esutil.EsSearchResponseHit{
+						{
+							Source: occurrenceJson,
+						},
+					},
+				},
+			}
+			expectedProjectSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: 1,
+					},
+					Hits: []*esutil.EsSearchResponseHit{
+						{
+							Source: projectJson,
+						},
+					},
+				},
+			}
+			expectedNoteSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: 1,
+					},
+					Hits: []*esutil.EsSearchResponseHit{
+						{
+							Source: noteJson,
+						},
+					},
+				},
+			}
+			expectedOccurrenceSearchError = nil
+			expectedProjectSearchError = nil
+			expectedNoteSearchError = nil
+		})
+
+		JustBeforeEach(func() {
+			client.SearchReturnsOnCall(0, expectedOccurrenceSearchResponse, expectedOccurrenceSearchError)
+			client.SearchReturnsOnCall(1, expectedProjectSearchResponse, expectedProjectSearchError)
+			client.SearchReturnsOnCall(2, expectedNoteSearchResponse, expectedNoteSearchError)
+
+			actualNote, actualErr = elasticsearchStorage.GetOccurrenceNote(ctx, expectedProjectId, expectedOccurrenceId)
+		})
+
+		It("should query elasticsearch for the specified occurrence", func() {
+			Expect(client.SearchCallCount()).To(BeNumerically(">=", 1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Index).To(Equal(expectedOccurrencesAlias))
+			Expect((*searchRequest.Search.Query.Term)["name"]).To(Equal(expectedOccurrenceName))
+		})
+
+		It("should check that the note's project exists", func() {
+			Expect(client.SearchCallCount()).To(BeNumerically(">=", 2))
+
+			_, searchRequest := client.SearchArgsForCall(1)
+
+			Expect(searchRequest.Index).To(Equal(expectedProjectAlias))
+			Expect((*searchRequest.Search.Query.Term)["name"]).To(Equal(fmt.Sprintf("projects/%s", expectedNoteProjectId)))
+		})
+
+		It("should query the note project's alias for the note", func() {
+			Expect(client.SearchCallCount()).To(Equal(3))
+
+			_, searchRequest := client.SearchArgsForCall(2)
+
+			Expect(searchRequest.Index).To(Equal(expectedNoteAlias))
+			Expect((*searchRequest.Search.Query.Term)["name"]).To(Equal(expectedNoteName))
+		})
+
+		It("should return the note and no error", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualNote).To(Equal(expectedNote))
+		})
+
+		When("the occurrence does not exist", func() {
+			BeforeEach(func() {
+				expectedOccurrenceSearchResponse.Hits.Total.Value = 0
+				expectedOccurrenceSearchResponse.Hits.Hits = []*esutil.EsSearchResponseHit{}
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+				Expect(actualNote).To(BeNil())
+			})
+
+			It("should not search for the note", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+			})
+		})
+
+		When("searching for the occurrence fails", func() {
+			BeforeEach(func() {
+				expectedOccurrenceSearchError = errors.New("failed search")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualNote).To(BeNil())
+			})
+		})
+
+		When("the occurrence has an invalid note name", func() {
+			BeforeEach(func() {
+				occurrence := generateTestOccurrence(expectedOccurrenceName)
+				occurrenceJson, err := protojson.Marshal(proto.MessageV2(occurrence))
+				Expect(err).ToNot(HaveOccurred())
+
+				expectedOccurrenceSearchResponse.Hits.Hits[0].Source = occurrenceJson
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualNote).To(BeNil())
+			})
+
+			It("should not search for the note", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+			})
+		})
+
+		When("the note's project does not exist", func() {
+			BeforeEach(func() {
+				expectedProjectSearchResponse.Hits.Total.Value = 0
+				expectedProjectSearchResponse.Hits.Hits = []*esutil.EsSearchResponseHit{}
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+				Expect(actualNote).To(BeNil())
+			})
+
+			It("should not search for the note", func() {
+				Expect(client.SearchCallCount()).To(Equal(2))
+			})
+		})
+
+		When("checking for the note's project fails", func() {
+			BeforeEach(func() {
+				expectedProjectSearchError = errors.New("failed search")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualNote).To(BeNil())
+			})
+		})
+
+		When("the note does not exist", func() {
+			BeforeEach(func() {
+				expectedNoteSearchResponse.Hits.Total.Value = 0
+				expectedNoteSearchResponse.Hits.Hits = []*esutil.EsSearchResponseHit{}
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+				Expect(actualNote).To(BeNil())
+			})
+		})
+
+		When("searching for the note fails", func() {
+			BeforeEach(func() {
+				expectedNoteSearchError = errors.New("failed search")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualNote).To(BeNil())
+			})
+		})
+	})
+
+	Context("ListNoteOccurrences", func() {
+		var (
+			actualErr           error
+			actualNextPageToken string
+			actualOccurrences   []*pb.Occurrence
+
+			expectedNoteId              string
+			expectedNoteName            string
+			expectedAllOccurrencesAlias string
+			expectedOccurrences         []*pb.Occurrence
+			expectedFilter              string
+			expectedPageSize            int
+			expectedPageToken           string
+			expectedNextPageToken       string
+
+			expectedSearchResponse *esutil.SearchResponse
+			expectedSearchError    error
+
+			expectedPrefix          string
+			expectedIndices         map[string]*esutil.EsIndex
+			expectedGetIndicesError error
+		)
+
+		occurrencesIndex := func(creationDate int64, aliases ...string) *esutil.EsIndex {
+			index := &esutil.EsIndex{
+				Aliases: map[string]interface{}{},
+			}
+			index.Settings.Index.CreationDate = strconv.FormatInt(creationDate, 10)
+			for _, alias := range aliases {
+				index.Aliases[alias] = map[string]interface{}{}
+			}
+
+			return index
+		}
+
+		BeforeEach(func() {
+			expectedNoteId = fake.LetterN(10)
+			expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+			expectedPrefix = fake.LetterN(10)
+			expectedAllOccurrencesAlias = expectedPrefix + "-*-occurrences"
+			expectedIndices = map[string]*esutil.EsIndex{
+				expectedPrefix + "-v1-a-occurrences": occurrencesIndex(1, expectedPrefix+"-a-occurrences"),
+				expectedPrefix + "-v1-b-occurrences": occurrencesIndex(2, expectedPrefix+"-b-occurrences"),
+			}
+			expectedGetIndicesError = nil
+			expectedFilter = ""
+			expectedOccurrences = generateTestOccurrences(fake.Number(2, 5))
+			expectedPageSize = fake.Number(10, 20)
+			expectedPageToken = fake.LetterN(10)
+
+			indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+				if documentKind == occurrencesDocumentKind && inner == "*" {
+					return expectedAllOccurrencesAlias
+				}
+
+				return ""
+			})
+
+			var expectedSearchResponseHits []*esutil.EsSearchResponseHit
+			for _, occurrence := range expectedOccurrences {
+				occurrence.NoteName = expectedNoteName
+				json, err := protojson.Marshal(proto.MessageV2(occurrence))
+				Expect(err).NotTo(HaveOccurred())
+
+				expectedSearchResponseHits = append(expectedSearchResponseHits, &esutil.EsSearchResponseHit{
+					Source: json,
+				})
+			}
+			expectedNextPageToken = fake.LetterN(10)
+			expectedSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: len(expectedOccurrences),
+					},
+					Hits: expectedSearchResponseHits,
+				},
+				NextPageToken: expectedNextPageToken,
+			}
+
+			expectedSearchError = nil
+		})
+
+		JustBeforeEach(func() {
+			client.GetIndicesReturns(expectedIndices, expectedGetIndicesError)
+			client.SearchReturns(expectedSearchResponse, expectedSearchError)
+			actualOccurrences, actualNextPageToken, actualErr = elasticsearchStorage.ListNoteOccurrences(ctx, expectedProjectId, expectedNoteId, expectedFilter, expectedPageToken, int32(expectedPageSize))
+		})
+
+		It("should query the occurrences of every project for the note", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Index).To(Equal(expectedAllOccurrencesAlias))
+
+			Expect(searchRequest.Pagination).ToNot(BeNil())
+			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
+			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
+
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+				{Field: tiebreakerSortField, Order: esutil.EsSortOrderAscending},
+			}))
+			Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+				Term: &filtering.Term{
+					"noteName": expectedNoteName,
+				},
+			}))
+		})
+
+		It("should look up the indices matched by the alias pattern", func() {
+			Expect(client.GetIndicesCallCount()).To(Equal(1))
+
+			_, index := client.GetIndicesArgsForCall(0)
+			Expect(index).To(Equal(expectedAllOccurrencesAlias))
+		})
+
+		When("a migration is reindexing a project's occurrences", func() {
+			BeforeEach(func() {
+				// the target index is created with the alias, which is still on the source index
+				expectedIndices[expectedPrefix+"-v2-a-occurrences"] = occurrencesIndex(3, expectedPrefix+"-a-occurrences")
+			})
+
+			It("should leave out the target index, so that occurrences aren't returned twice", func() {
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+					Bool: &filtering.Bool{
+						Must: &filtering.Must{
+							&filtering.Query{
+								Term: &filtering.Term{
+									"noteName": expectedNoteName,
+								},
+							},
+						},
+						MustNot: &filtering.MustNot{
+							&filtering.Query{
+								Terms: &filtering.Terms{
+									"_index": {expectedPrefix + "-v2-a-occurrences"},
+								},
+							},
+						},
+					},
+				}))
+			})
+		})
+
+		When("a migration has swapped the alias, but not deleted the source index", func() {
+			BeforeEach(func() {
+				expectedIndices[expectedPrefix+"-v1-a-occurrences"] = occurrencesIndex(1)
+				expectedIndices[expectedPrefix+"-v2-a-occurrences"] = occurrencesIndex(3, expectedPrefix+"-a-occurrences")
+			})
+
+			It("should leave out the source index", func() {
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query.Bool.MustNot).To(Equal(&filtering.MustNot{
+					&filtering.Query{
+						Terms: &filtering.Terms{
+							"_index": {expectedPrefix + "-v1-a-occurrences"},
+						},
+					},
+				}))
+			})
+		})
+
+		When("looking up the indices fails", func() {
+			BeforeEach(func() {
+				expectedGetIndicesError = errors.New("get indices failed")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualOccurrences).To(BeNil())
+			})
+
+			It("should not search for the occurrences", func() {
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+		})
+
+		When("a valid filter is specified", func() {
+			var expectedFilterQuery *filtering.Query
+
+			BeforeEach(func() {
+				expectedFilterQuery = &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, "occurrences").
+					Return(expectedFilterQuery, nil)
+			})
+
+			It("should combine the note query with the parsed filter", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+					Bool: &filtering.Bool{
+						Must: &filtering.Must{
+							&filtering.Query{
+								Term: &filtering.Term{
+									"noteName": expectedNoteName,
+								},
+							},
+							expectedFilterQuery,
+						},
+					},
+				}))
+			})
+		})
+
+		When("an invalid filter is specified", func() {
+			BeforeEach(func() {
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, "occurrences").
+					Return(nil, errors.New(fake.LetterN(10)))
+			})
+
+			It("should not send a request to elasticsearch", func() {
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+				Expect(actualOccurrences).To(BeNil())
+				Expect(actualNextPageToken).To(BeEmpty())
+			})
+		})
+
+		When("elasticsearch successfully returns occurrence(s)", func() {
+			It("should return the Grafeas occurrence(s)", func() {
+				Expect(actualOccurrences).To(Equal(expectedOccurrences))
+			})
+
+			It("should return the next page token", func() {
+				Expect(actualNextPageToken).To(Equal(expectedNextPageToken))
+			})
+
+			It("should return without an error", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+			})
+		})
+
+		When("elasticsearch returns zero hits", func() {
+			BeforeEach(func() {
+				expectedSearchResponse.Hits.Total.Value = 0
+				expectedSearchResponse.Hits.Hits = []*esutil.EsSearchResponseHit{}
+			})
+
+			It("should return an empty slice of grafeas occurrences", func() {
+				Expect(actualOccurrences).To(BeNil())
+			})
+
+			It("should not return an error", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+			})
+		})
+
+		When("elasticsearch returns an error", func() {
+			BeforeEach(func() {
+				expectedSearchError = errors.New("search error")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+	})
+
+	Context("GetVulnerabilityOccurrencesSummary", func() {
+		var (
+			actualErr     error
+			actualSummary *pb.VulnerabilityOccurrencesSummary
+
+			expectedFilter      string
+			expectedResourceUri string
+
+			expectedSearchResponse *esutil.SearchResponse
+			expectedSearchError    error
+		)
+
+		BeforeEach(func() {
+			expectedFilter = ""
+			expectedResourceUri = fake.URL()
+
+			expectedSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: 5,
+					},
+				},
+				Aggregations: map[string]*esutil.EsAggregationResult{
+					resourcesAggregationName: {
+						Buckets: []*esutil.EsAggregationBucket{
+							{
+								Key: map[string]interface{}{
+									resourcesAggregationName: expectedResourceUri,
+								},
+								DocCount: 5,
+								Aggregations: map[string]*esutil.EsAggregationResult{
+									fixableAggregationName: {
+										DocCount: 3,
+									},
+									severitiesAggregationName: {
+										Buckets: []*esutil.EsAggregationBucket{
+											{
+												Key:      vulnerability_go_proto.Severity_CRITICAL.String(),
+												DocCount: 4,
+												Aggregations: map[string]*esutil.EsAggregationResult{
+													fixableAggregationName: {
+														DocCount: 3,
+													},
+												},
+											},
+											{
+												Key:      vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED.String(),
+												DocCount: 1,
+												Aggregations: map[string]*esutil.EsAggregationResult{
+													fixableAggregationName: {
+														DocCount: 0,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+			expectedSearchError = nil
+		})
+
+		JustBeforeEach(func() {
+			client.SearchReturns(expectedSearchResponse, expectedSearchError)
+
+			actualSummary, actualErr = elasticsearchStorage.GetVulnerabilityOccurrencesSummary(ctx, expectedProjectId, expectedFilter)
+		})
+
+		It("should aggregate the project's vulnerability occurrences", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Index).To(Equal(expectedOccurrencesAlias))
+			Expect(searchRequest.Pagination).To(BeNil())
+			Expect(*searchRequest.Search.Size).To(Equal(0))
+			Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+				Term: &filtering.Term{
+					"kind": common_go_proto.NoteKind_VULNERABILITY.String(),
+				},
+			}))
+
+			resources := searchRequest.Search.Aggregations[resourcesAggregationName]
+			Expect(resources).ToNot(BeNil())
+			Expect(resources.Composite.Size).To(Equal(vulnerabilitySummaryPageSize))
+			Expect(resources.Composite.Sources).To(HaveLen(1))
+			Expect(resources.Composite.Sources[0][resourcesAggregationName].Terms.Field).To(Equal("resource.uri"))
+			Expect(resources.Composite.After).To(BeNil())
+
+			severities := resources.Aggregations[severitiesAggregationName]
+			Expect(severities).ToNot(BeNil())
+			Expect(severities.Terms.Field).To(Equal("vulnerability.severity"))
+
+			Expect(resources.Aggregations[fixableAggregationName].Filter).ToNot(BeNil())
+			Expect(severities.Aggregations[fixableAggregationName].Filter).ToNot(BeNil())
+		})
+
+		It("should return the counts for each resource", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary.Counts).To(ConsistOf(
+				&pb.VulnerabilityOccurrencesSummary_FixableTotalByDigest{
+					Resource: &pb.Resource{
+						Uri: expectedResourceUri,
+					},
+					Severity:     vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED,
+					FixableCount: 3,
+					TotalCount:   5,
+				},
+				&pb.VulnerabilityOccurrencesSummary_FixableTotalByDigest{
+					Resource: &pb.Resource{
+						Uri: expectedResourceUri,
+					},
+					Severity:     vulnerability_go_proto.Severity_CRITICAL,
+					FixableCount: 3,
+					TotalCount:   4,
+				},
+			))
+		})
+
+		When("a valid filter is specified", func() {
+			var expectedFilterQuery *filtering.Query
+
+			BeforeEach(func() {
+				expectedFilterQuery = &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, "occurrences").
+					Return(expectedFilterQuery, nil)
+			})
+
+			It("should only aggregate vulnerability occurrences that match the filter", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+					Bool: &filtering.Bool{
+						Must: &filtering.Must{
+							&filtering.Query{
+								Term: &filtering.Term{
+									"kind": common_go_proto.NoteKind_VULNERABILITY.String(),
+								},
+							},
+							expectedFilterQuery,
+						},
+					},
+				}))
+			})
+		})
+
+		When("an invalid filter is specified", func() {
+			BeforeEach(func() {
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, "occurrences").
+					Return(nil, errors.New(fake.LetterN(10)))
+			})
+
+			It("should not send a request to elasticsearch", func() {
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+				Expect(actualSummary).To(BeNil())
+			})
+		})
+
+		When("there are more resources than fit on one page", func() {
+			var (
+				expectedAfterKey        map[string]interface{}
+				expectedNextResourceUri string
+			)
+
+			BeforeEach(func() {
+				expectedAfterKey = map[string]interface{}{
+					resourcesAggregationName: expectedResourceUri,
+				}
+				expectedSearchResponse.Aggregations[resourcesAggregationName].AfterKey = expectedAfterKey
+				expectedNextResourceUri = fake.URL()
+
+				client.SearchReturnsOnCall(1, &esutil.SearchResponse{
+					Aggregations: map[string]*esutil.EsAggregationResult{
+						resourcesAggregationName: {
+							Buckets: []*esutil.EsAggregationBucket{
+								{
+									Key: map[string]interface{}{
+										resourcesAggregationName: expectedNextResourceUri,
+									},
+									DocCount: 2,
+								},
+							},
+							AfterKey: map[string]interface{}{
+								resourcesAggregationName: expectedNextResourceUri,
+							},
+						},
+					},
+				}, nil)
+				client.SearchReturnsOnCall(2, &esutil.SearchResponse{
+					Aggregations: map[string]*esutil.EsAggregationResult{
+						resourcesAggregationName: {},
+					},
+				}, nil)
+			})
+
+			It("should request each page after the previous one", func() {
+				Expect(client.SearchCallCount()).To(Equal(3))
+
+				_, secondRequest := client.SearchArgsForCall(1)
+				Expect(secondRequest.Search.Aggregations[resourcesAggregationName].Composite.After).To(Equal(expectedAfterKey))
+			})
+
+			It("should return the counts for the resources on every page", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualSummary.Counts).To(HaveLen(3))
+				Expect(actualSummary.Counts[2]).To(Equal(&pb.VulnerabilityOccurrencesSummary_FixableTotalByDigest{
+					Resource: &pb.Resource{
+						Uri: expectedNextResourceUri,
+					},
+					Severity:   vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED,
+					TotalCount: 2,
+				}))
+			})
+
+			When("a later page fails", func() {
+				BeforeEach(func() {
+					client.SearchReturnsOnCall(1, nil, errors.New("search failed"))
+				})
+
+				It("should return an error instead of a partial summary", func() {
+					assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+					Expect(actualSummary).To(BeNil())
+				})
+			})
+		})
+
+		When("there are no vulnerability occurrences", func() {
+			BeforeEach(func() {
+				expectedSearchResponse.Aggregations[resourcesAggregationName].Buckets = nil
+			})
+
+			It("should return an empty summary", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualSummary.Counts).To(BeEmpty())
+			})
+		})
+
+		When("elasticsearch returns an error", func() {
+			BeforeEach(func() {
+				expectedSearchError = errors.New("search failed")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualSummary).To(BeNil())
+			})
+		})
+	})
+
+	Context("FilterSchemas", func() {
+		var filterConfig *config.FilterConfig
+
+		BeforeEach(func() {
+			filterConfig = &config.FilterConfig{}
+		})
+
+		It("should return a schema for each document kind", func() {
+			Expect(FilterSchemas()).To(HaveLen(3))
+		})
+
+		When("schema validation is enabled", func() {
+			BeforeEach(func() {
+				filterConfig.ValidateSchema = true
+			})
+
+			It("should reject filters on fields that don't exist", func() {
+				filterer := filtering.NewFiltererWithConfig(filterConfig, FilterSchemas())
+
+				_, err := filterer.ParseExpression(`resource.urii == "x"`, occurrencesDocumentKind)
+
+				Expect(err).To(MatchError(ContainSubstring("resource.urii")))
+			})
+		})
+
+		When("schema validation is disabled", func() {
+			It("should allow filters on any field", func() {
+				filterer := filtering.NewFiltererWithConfig(filterConfig, FilterSchemas())
+
+				_, err := filterer.ParseExpression(`resource.urii == "x"`, occurrencesDocumentKind)
+
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should still exclude zero values of scalar fields from has()", func() {
+				filterer := filtering.NewFiltererWithConfig(filterConfig, FilterSchemas())
+
+				query, err := filterer.ParseExpression(`has(resource.uri)`, occurrencesDocumentKind)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(query.Bool.MustNot).To(Equal(&filtering.MustNot{
+					&filtering.Query{
+						Term: &filtering.Term{
+							"resource.uri": "",
+						},
+					},
+				}))
 			})
 		})
 	})
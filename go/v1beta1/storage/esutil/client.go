@@ -17,14 +17,18 @@ package esutil
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rode/grafeas-elasticsearch/go/config"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -92,6 +96,7 @@ type SearchPaginationOptions struct {
 
 type SearchResponse struct {
 	Hits          *EsSearchResponseHits
+	Aggregations  map[string]*EsAggregationResult
 	NextPageToken string
 }
 
@@ -101,6 +106,10 @@ type UpdateRequest struct {
 	Refresh    string // TODO: use RefreshOption type
 	Message    proto.Message
 	Routing    string
+	// IfSeqNo and IfPrimaryTerm make the update conditional on the document not having changed since it was read.
+	// If the document was changed, ErrVersionConflict is returned.
+	IfSeqNo       *int
+	IfPrimaryTerm *int
 }
 
 type DeleteRequest struct {
@@ -110,7 +119,10 @@ type DeleteRequest struct {
 	Routing string
 }
 
-const defaultPitKeepAlive = "5m"
+const defaultPitKeepAlive = config.DefaultKeepalive
+
+// closePitTimeout bounds closing a point in time, which is done even if the search's context has been cancelled
+const closePitTimeout = 10 * time.Second
 const maxPageSize = 1000
 
 //counterfeiter:generate . Client
@@ -120,6 +132,8 @@ type Client interface {
 	Search(ctx context.Context, request *SearchRequest) (*SearchResponse, error)
 	MultiSearch(ctx context.Context, request *MultiSearchRequest) (*EsMultiSearchResponse, error)
 	Get(ctx context.Context, request *GetRequest) (*EsGetResponse, error)
+	// GetIndices returns the aliases and creation date of each index that matches the pattern
+	GetIndices(ctx context.Context, index string) (map[string]*EsIndex, error)
 	MultiGet(ctx context.Context, request *MultiGetRequest) (*EsMultiGetResponse, error)
 	Update(ctx context.Context, request *UpdateRequest) (*EsIndexDocResponse, error)
 	Delete(ctx context.Context, request *DeleteRequest) error
@@ -128,18 +142,55 @@ type Client interface {
 type client struct {
 	logger   *zap.Logger
 	esClient *elasticsearch.Client
+	// tokenKey signs page tokens
+	tokenKey []byte
+	pits     *openPits
+	// requestTimeout and bulkTimeout limit how long each call can take, when they're set
+	requestTimeout time.Duration
+	bulkTimeout    time.Duration
 }
 
+// NewClient returns a Client that signs page tokens with a random key, and doesn't time out requests
 func NewClient(logger *zap.Logger, esClient *elasticsearch.Client) Client {
+	return NewClientWithConfig(logger, esClient, &config.ElasticsearchConfig{})
+}
+
+func NewClientWithConfig(logger *zap.Logger, esClient *elasticsearch.Client, c *config.ElasticsearchConfig) Client {
+	tokenKey := []byte(c.Pagination.TokenKey)
+	if len(tokenKey) == 0 {
+		logger.Warn("pagination.tokenKey is not set, page tokens will only be valid for this instance")
+
+		tokenKey = make([]byte, sha256.Size)
+		if _, err := rand.Read(tokenKey); err != nil {
+			logger.Fatal("error generating page token key", zap.Error(err))
+		}
+	}
+
 	return &client{
 		logger,
 		esClient,
+		tokenKey,
+		newOpenPits(c.Pagination.OpenPits()),
+		c.Timeouts.RequestTimeout(),
+		c.Timeouts.BulkTimeout(),
 	}
 }
 
+// withTimeout limits the context to the given timeout, if there is one
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
 func (c *client) Create(ctx context.Context, request *CreateRequest) (string, error) {
 	log := c.logger.Named("Create")
 
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
 	if request.Refresh == "" {
 		request.Refresh = "true"
 	}
@@ -186,7 +237,7 @@ func (c *client) Create(ctx context.Context, request *CreateRequest) (string, er
 		return "", err
 	}
 	if res.IsError() {
-		return "", fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return "", newResponseError(res)
 	}
 
 	esResponse := EsIndexDocResponse{}
@@ -202,6 +253,9 @@ func (c *client) Create(ctx context.Context, request *CreateRequest) (string, er
 func (c *client) Bulk(ctx context.Context, request *BulkRequest) (*EsBulkResponse, error) {
 	log := c.logger.Named("Bulk")
 
+	ctx, cancel := withTimeout(ctx, c.bulkTimeout)
+	defer cancel()
+
 	// build the request body using newline delimited JSON (ndjson)
 	// each message is represented by two JSON structures:
 	// the first is the metadata that represents the ES operation, in this case "index"
@@ -272,7 +326,7 @@ func (c *client) Bulk(ctx context.Context, request *BulkRequest) (*EsBulkRespons
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return nil, newResponseError(res)
 	}
 
 	var response EsBulkResponse
@@ -285,6 +339,9 @@ func (c *client) Bulk(ctx context.Context, request *BulkRequest) (*EsBulkRespons
 
 func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchResponse, error) {
 	log := c.logger.Named("Search")
+
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
 	response := &SearchResponse{}
 
 	body := &EsSearch{}
@@ -301,44 +358,55 @@ func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchRes
 	}
 
 	var (
-		searchFrom int
-		pitId      string
+		pitId     string
+		queryHash string
+		keepalive time.Duration
 	)
 	if request.Pagination != nil {
 		var err error
 		log = log.With(zap.String("pageToken", request.Pagination.Token), zap.Int("pageSize", request.Pagination.Size))
 
+		// search_after continues from the sort values of the last hit, so they need to uniquely identify each document
+		if len(body.Sort) == 0 {
+			return nil, errors.New("paginated searches must be sorted")
+		}
+
+		queryHash, err = HashQuery(body)
+		if err != nil {
+			return nil, err
+		}
+
 		if request.Pagination.Keepalive == "" {
 			request.Pagination.Keepalive = defaultPitKeepAlive
 		}
 
+		keepalive, err = config.ParseKeepalive(request.Pagination.Keepalive)
+		if err != nil {
+			return nil, err
+		}
+
 		// if no page token is specified, we need to create a new PIT
 		if request.Pagination.Token == "" {
-			res, err := c.esClient.OpenPointInTime(
-				c.esClient.OpenPointInTime.WithContext(ctx),
-				c.esClient.OpenPointInTime.WithIndex(request.Index),
-				c.esClient.OpenPointInTime.WithKeepAlive(request.Pagination.Keepalive),
-			)
+			pitId, err = c.openPit(ctx, request.Index, request.Pagination.Keepalive, keepalive)
 			if err != nil {
 				return nil, err
 			}
-			if res.IsError() {
-				return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
-			}
 
-			var pitResponse ESPitResponse
-			if err = DecodeResponse(res.Body, &pitResponse); err != nil {
-				return nil, err
-			}
-
-			pitId = pitResponse.Id
-			searchFrom = 0
+			body.SearchAfter = nil
 		} else {
-			// get the PIT from the provided page token
-			pitId, searchFrom, err = ParsePageToken(request.Pagination.Token)
+			// get the PIT and the position of the previous page from the provided page token
+			pageToken, err := ParsePageToken(c.tokenKey, request.Pagination.Token)
 			if err != nil {
 				return nil, err
 			}
+
+			// a page token can't be used to continue a different search
+			if pageToken.Index != request.Index || pageToken.QueryHash != queryHash {
+				return nil, fmt.Errorf("%w: the page token was created for a different search", ErrInvalidPageToken)
+			}
+
+			pitId = pageToken.Cursor.PitId
+			body.SearchAfter = pageToken.Cursor.SearchAfter
 		}
 
 		body.Pit = &EsSearchPit{
@@ -346,29 +414,84 @@ func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchRes
 			KeepAlive: request.Pagination.Keepalive,
 		}
 
-		searchOptions = append(searchOptions,
-			c.esClient.Search.WithFrom(searchFrom),
-			c.esClient.Search.WithSize(request.Pagination.Size),
-		)
+		// one extra hit is requested to find out whether there's another page
+		searchOptions = append(searchOptions, c.esClient.Search.WithSize(request.Pagination.Size+1))
 	} else {
-		searchOptions = append(searchOptions,
-			c.esClient.Search.WithIndex(request.Index),
-			c.esClient.Search.WithSize(maxPageSize),
-		)
+		searchOptions = append(searchOptions, c.esClient.Search.WithIndex(request.Index))
+
+		if body.Size == nil {
+			searchOptions = append(searchOptions, c.esClient.Search.WithSize(maxPageSize))
+		}
 	}
 
 	encodedBody, requestJson := EncodeRequest(body)
 	log = log.With(zap.String("request", requestJson))
 	log.Debug("performing search")
 
-	res, err := c.esClient.Search(
-		append(searchOptions, c.esClient.Search.WithBody(encodedBody))...,
-	)
+	searchResults, err := c.search(append(searchOptions, c.esClient.Search.WithBody(encodedBody))...)
+	if err != nil {
+		// a PIT that was just opened can't be used again, as the client doesn't have a page token for it.
+		// Otherwise the PIT is kept after transient errors, so that the same page token can be retried.
+		if pitId != "" && (request.Pagination.Token == "" || !isTransient(err)) {
+			c.closePit(log, pitId)
+		}
+
+		return nil, err
+	}
+
+	response.Hits = searchResults.Hits
+	response.Aggregations = searchResults.Aggregations
+
+	if request.Pagination == nil {
+		// searches that need every hit should be paginated instead
+		if response.Hits != nil && response.Hits.Total != nil && response.Hits.Total.Value > len(response.Hits.Hits) && len(response.Hits.Hits) == maxPageSize {
+			log.Warn("search without pagination returned a partial result", zap.Int("total", response.Hits.Total.Value))
+		}
+
+		return response, nil
+	}
+
+	// the PIT id can change between searches, and the latest one should always be used
+	previousPitId := pitId
+	if searchResults.PitId != "" {
+		pitId = searchResults.PitId
+	}
+
+	hasNextPage := len(response.Hits.Hits) > request.Pagination.Size
+	if hasNextPage {
+		response.Hits.Hits = response.Hits.Hits[:request.Pagination.Size]
+	}
+
+	// the next page starts after the last hit on this one
+	if hasNextPage && request.Pagination.Size > 0 {
+		lastHit := response.Hits.Hits[request.Pagination.Size-1]
+
+		nextPageToken, err := CreatePageToken(c.tokenKey, request.Index, queryHash, Cursor{
+			PitId:       pitId,
+			SearchAfter: lastHit.Sort,
+		})
+		if err != nil {
+			c.closePit(log, pitId, previousPitId)
+			return nil, err
+		}
+
+		response.NextPageToken = nextPageToken
+		c.pits.extend(previousPitId, pitId, keepalive)
+	} else {
+		// this is the last page
+		c.closePit(log, pitId, previousPitId)
+	}
+
+	return response, nil
+}
+
+func (c *client) search(searchOptions ...func(*esapi.SearchRequest)) (*EsSearchResponse, error) {
+	res, err := c.esClient.Search(searchOptions...)
 	if err != nil {
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return nil, newResponseError(res)
 	}
 
 	var searchResults EsSearchResponse
@@ -376,21 +499,72 @@ func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchRes
 		return nil, err
 	}
 
-	response.Hits = searchResults.Hits
-	if request.Pagination != nil {
-		nextSearchFrom := searchFrom + request.Pagination.Size
+	return &searchResults, nil
+}
 
-		if nextSearchFrom < response.Hits.Total.Value {
-			response.NextPageToken = CreatePageToken(pitId, nextSearchFrom)
-		}
+// openPit opens a point in time for a paginated search, as long as the limit on open PITs hasn't been reached
+func (c *client) openPit(ctx context.Context, index, keepalive string, keepaliveDuration time.Duration) (string, error) {
+	if err := c.pits.reserve(); err != nil {
+		return "", err
 	}
 
-	return response, nil
+	res, err := c.esClient.OpenPointInTime(
+		c.esClient.OpenPointInTime.WithContext(ctx),
+		c.esClient.OpenPointInTime.WithIndex(index),
+		c.esClient.OpenPointInTime.WithKeepAlive(keepalive),
+	)
+	if err != nil {
+		c.pits.release()
+		return "", err
+	}
+	if res.IsError() {
+		c.pits.release()
+		return "", newResponseError(res)
+	}
+
+	var pitResponse ESPitResponse
+	if err = DecodeResponse(res.Body, &pitResponse); err != nil {
+		c.pits.release()
+		return "", err
+	}
+
+	c.pits.add(pitResponse.Id, keepaliveDuration)
+
+	return pitResponse.Id, nil
+}
+
+// closePit closes a point in time that's no longer needed, rather than waiting for it to expire.
+// Failures are only logged, as the PIT will expire on its own.
+// Any previous ids of the same PIT are no longer tracked either.
+func (c *client) closePit(log *zap.Logger, pitId string, previousPitIds ...string) {
+	c.pits.remove(append(previousPitIds, pitId)...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), closePitTimeout)
+	defer cancel()
+
+	encodedBody, _ := EncodeRequest(&EsClosePitRequest{Id: pitId})
+	res, err := c.esClient.ClosePointInTime(
+		c.esClient.ClosePointInTime.WithContext(ctx),
+		c.esClient.ClosePointInTime.WithBody(encodedBody),
+	)
+	if err != nil {
+		log.Warn("error closing point in time", zap.Error(err))
+		return
+	}
+	defer res.Body.Close()
+
+	// the PIT may have already expired
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		log.Warn("error closing point in time", zap.Error(newResponseError(res)))
+	}
 }
 
 func (c *client) MultiSearch(ctx context.Context, request *MultiSearchRequest) (*EsMultiSearchResponse, error) {
 	log := c.logger.Named("MultiSearch")
 
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
 	searchMetadataWithoutRouting, _ := json.Marshal(&EsMultiSearchQueryFragment{
 		Index: request.Index,
 	})
@@ -426,7 +600,7 @@ func (c *client) MultiSearch(ctx context.Context, request *MultiSearchRequest) (
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return nil, newResponseError(res)
 	}
 
 	var response EsMultiSearchResponse
@@ -440,6 +614,9 @@ func (c *client) MultiSearch(ctx context.Context, request *MultiSearchRequest) (
 }
 
 func (c *client) Get(ctx context.Context, request *GetRequest) (*EsGetResponse, error) {
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
 	log := c.logger.Named("Get").With(zap.String("index", request.Index), zap.String("documentId", request.DocumentId))
 	getOpts := []func(*esapi.GetRequest){
 		c.esClient.Get.WithContext(ctx),
@@ -458,7 +635,7 @@ func (c *client) Get(ctx context.Context, request *GetRequest) (*EsGetResponse,
 		return nil, err
 	}
 	if res.IsError() && res.StatusCode != http.StatusNotFound {
-		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return nil, newResponseError(res)
 	}
 
 	var response EsGetResponse
@@ -471,9 +648,40 @@ func (c *client) Get(ctx context.Context, request *GetRequest) (*EsGetResponse,
 	return &response, nil
 }
 
+func (c *client) GetIndices(ctx context.Context, index string) (map[string]*EsIndex, error) {
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	log := c.logger.Named("GetIndices").With(zap.String("index", index))
+
+	res, err := c.esClient.Indices.Get(
+		[]string{index},
+		c.esClient.Indices.Get.WithContext(ctx),
+		c.esClient.Indices.Get.WithFilterPath("*.aliases", "*.settings.index.creation_date"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, newResponseError(res)
+	}
+
+	indices := map[string]*EsIndex{}
+	if err = DecodeResponse(res.Body, &indices); err != nil {
+		return nil, err
+	}
+
+	log.Debug("elasticsearch response", zap.Any("response", indices))
+
+	return indices, nil
+}
+
 func (c *client) MultiGet(ctx context.Context, request *MultiGetRequest) (*EsMultiGetResponse, error) {
 	log := c.logger.Named("MultiGet")
 
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
 	encodedBody, requestJson := EncodeRequest(&EsMultiGetRequest{
 		IDs:  request.DocumentIds,
 		Docs: request.Items,
@@ -496,7 +704,7 @@ func (c *client) MultiGet(ctx context.Context, request *MultiGetRequest) (*EsMul
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return nil, newResponseError(res)
 	}
 
 	var response EsMultiGetResponse
@@ -511,6 +719,9 @@ func (c *client) MultiGet(ctx context.Context, request *MultiGetRequest) (*EsMul
 
 func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDocResponse, error) {
 	log := c.logger.Named("Update")
+
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
 	str, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(request.Message)
 	if err != nil {
 		return nil, err
@@ -530,6 +741,13 @@ func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDo
 		indexOpts = append(indexOpts, c.esClient.Index.WithRouting(request.Routing))
 	}
 
+	if request.IfSeqNo != nil && request.IfPrimaryTerm != nil {
+		indexOpts = append(indexOpts,
+			c.esClient.Index.WithIfSeqNo(*request.IfSeqNo),
+			c.esClient.Index.WithIfPrimaryTerm(*request.IfPrimaryTerm),
+		)
+	}
+
 	res, err := c.esClient.Index(
 		request.Index,
 		bytes.NewReader(str),
@@ -539,7 +757,7 @@ func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDo
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return nil, newResponseError(res)
 	}
 
 	esResponse := EsIndexDocResponse{}
@@ -554,6 +772,9 @@ func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDo
 
 func (c *client) Delete(ctx context.Context, request *DeleteRequest) error {
 	log := c.logger.Named("Delete")
+
+	ctx, cancel := withTimeout(ctx, c.requestTimeout)
+	defer cancel()
 	encodedBody, requestJson := EncodeRequest(request.Search)
 	log = log.With(zap.String("request", requestJson))
 
@@ -579,7 +800,7 @@ func (c *client) Delete(ctx context.Context, request *DeleteRequest) error {
 		return err
 	}
 	if res.IsError() {
-		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+		return newResponseError(res)
 	}
 
 	deletedResults := EsDeleteResponse{}
@@ -588,7 +809,7 @@ func (c *client) Delete(ctx context.Context, request *DeleteRequest) error {
 	}
 
 	if deletedResults.Deleted == 0 {
-		return errors.New("elasticsearch returned zero deleted documents")
+		return ErrNotFound
 	}
 
 	return nil
@@ -17,13 +17,16 @@ package esutil
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
@@ -32,6 +35,7 @@ import (
 	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/rode/grafeas-elasticsearch/go/config"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -43,17 +47,25 @@ var _ = Describe("elasticsearch client", func() {
 		client    Client
 		transport *MockEsTransport
 		ctx       context.Context
+		tokenKey  string
+		esConfig  *config.ElasticsearchConfig
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
 
 		transport = &MockEsTransport{}
+		tokenKey = fake.LetterN(32)
+		esConfig = &config.ElasticsearchConfig{
+			Pagination: config.PaginationConfig{
+				TokenKey: tokenKey,
+			},
+		}
 	})
 
 	JustBeforeEach(func() {
 		mockEsClient := &elasticsearch.Client{Transport: transport, API: esapi.New(transport)}
-		client = NewClient(logger, mockEsClient)
+		client = NewClientWithConfig(logger, mockEsClient, esConfig)
 	})
 
 	Context("Create", func() {
@@ -498,9 +510,11 @@ var _ = Describe("elasticsearch client", func() {
 				Hits: &EsSearchResponseHits{
 					Hits: []*EsSearchResponseHit{
 						{
-							ID:         fake.LetterN(10),
-							Source:     []byte("{}"),
-							Highlights: []byte("{}"),
+							ID:     fake.LetterN(10),
+							Source: []byte("{}"),
+							Highlights: map[string][]string{
+								fake.LetterN(10): {fake.LetterN(10)},
+							},
 						},
 					},
 					Total: &EsSearchResponseTotal{
@@ -581,6 +595,387 @@ var _ = Describe("elasticsearch client", func() {
 			})
 		})
 
+		When("aggregations are requested", func() {
+			var (
+				expectedSearch      *EsSearch
+				expectedAggregation string
+				expectedField       string
+				expectedBucketKey   string
+				expectedDocCount    int
+				size                int
+			)
+
+			BeforeEach(func() {
+				expectedAggregation = fake.LetterN(10)
+				expectedField = fake.LetterN(10)
+				expectedBucketKey = fake.LetterN(10)
+				expectedDocCount = fake.Number(1, 100)
+				size = 0
+
+				expectedSearch = &EsSearch{
+					Size: &size,
+					Aggregations: map[string]*EsAggregation{
+						expectedAggregation: {
+							Terms: &EsTermsAggregation{
+								Field: expectedField,
+							},
+							Aggregations: map[string]*EsAggregation{
+								"filtered": {
+									Filter: &filtering.Query{
+										Term: &filtering.Term{
+											expectedField: expectedBucketKey,
+										},
+									},
+								},
+							},
+						},
+					},
+				}
+				expectedSearchRequest.Search = expectedSearch
+
+				transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+					"hits": {"total": {"value": %[3]d}, "hits": []},
+					"aggregations": {
+						"%[1]s": {
+							"doc_count_error_upper_bound": 0,
+							"sum_other_doc_count": 0,
+							"buckets": [
+								{"key": "%[2]s", "doc_count": %[3]d, "filtered": {"doc_count": 1}}
+							]
+						}
+					}
+				}`, expectedAggregation, expectedBucketKey, expectedDocCount)))
+			})
+
+			It("should send the aggregations in the search request", func() {
+				searchRequest := &EsSearch{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+				Expect(searchRequest.Aggregations).To(BeEquivalentTo(expectedSearch.Aggregations))
+			})
+
+			It("should not request any hits", func() {
+				Expect(transport.ReceivedHttpRequests[0].URL.Query().Has("size")).To(BeFalse())
+
+				searchRequest := &EsSearch{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+				Expect(*searchRequest.Size).To(Equal(0))
+			})
+
+			It("should return the decoded aggregation results", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				aggregation := actualSearchResponse.Aggregations[expectedAggregation]
+				Expect(aggregation).ToNot(BeNil())
+				Expect(aggregation.Buckets).To(HaveLen(1))
+
+				bucket := aggregation.Buckets[0]
+				Expect(bucket.Key).To(Equal(expectedBucketKey))
+				Expect(bucket.DocCount).To(Equal(expectedDocCount))
+				Expect(bucket.Aggregations).To(HaveLen(1))
+				Expect(bucket.Aggregations["filtered"].DocCount).To(Equal(1))
+			})
+
+			When("a date_histogram aggregation is used", func() {
+				var expectedTimestamp int64
+
+				BeforeEach(func() {
+					expectedTimestamp = fake.Date().UnixMilli()
+					expectedSearch.Aggregations = map[string]*EsAggregation{
+						expectedAggregation: {
+							DateHistogram: &EsDateHistogramAggregation{
+								Field:            expectedField,
+								CalendarInterval: "week",
+								Format:           "yyyy-MM-dd",
+							},
+						},
+					}
+
+					transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+						"hits": {"total": {"value": %[3]d}, "hits": []},
+						"aggregations": {
+							"%[1]s": {
+								"buckets": [
+									{"key_as_string": "2021-01-04", "key": %[2]d, "doc_count": %[3]d}
+								]
+							}
+						}
+					}`, expectedAggregation, expectedTimestamp, expectedDocCount)))
+				})
+
+				It("should send the date_histogram aggregation", func() {
+					searchRequest := map[string]interface{}{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest["aggs"]).To(Equal(map[string]interface{}{
+						expectedAggregation: map[string]interface{}{
+							"date_histogram": map[string]interface{}{
+								"field":             expectedField,
+								"calendar_interval": "week",
+								"format":            "yyyy-MM-dd",
+							},
+						},
+					}))
+				})
+
+				It("should decode the date buckets", func() {
+					bucket := actualSearchResponse.Aggregations[expectedAggregation].Buckets[0]
+
+					Expect(bucket.Key).To(BeNumerically("==", expectedTimestamp))
+					Expect(bucket.KeyAsString).To(Equal("2021-01-04"))
+					Expect(bucket.DocCount).To(Equal(expectedDocCount))
+				})
+			})
+
+			When("a composite aggregation is used", func() {
+				BeforeEach(func() {
+					expectedSearch.Aggregations = map[string]*EsAggregation{
+						expectedAggregation: {
+							Composite: &EsCompositeAggregation{
+								Size: 10,
+								Sources: []map[string]*EsAggregation{
+									{
+										"source": {
+											Terms: &EsTermsAggregation{
+												Field: expectedField,
+											},
+										},
+									},
+								},
+								After: map[string]interface{}{
+									"source": fake.LetterN(10),
+								},
+							},
+						},
+					}
+
+					transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+						"hits": {"total": {"value": %[3]d}, "hits": []},
+						"aggregations": {
+							"%[1]s": {
+								"after_key": {"source": "%[2]s"},
+								"buckets": [
+									{"key": {"source": "%[2]s"}, "doc_count": %[3]d}
+								]
+							}
+						}
+					}`, expectedAggregation, expectedBucketKey, expectedDocCount)))
+				})
+
+				It("should send the composite aggregation", func() {
+					searchRequest := &EsSearch{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest.Aggregations).To(BeEquivalentTo(expectedSearch.Aggregations))
+				})
+
+				It("should decode the bucket keys and the after key", func() {
+					aggregation := actualSearchResponse.Aggregations[expectedAggregation]
+
+					Expect(aggregation.AfterKey).To(Equal(map[string]interface{}{"source": expectedBucketKey}))
+					Expect(aggregation.Aggregations).To(BeEmpty())
+					Expect(aggregation.Buckets).To(HaveLen(1))
+					Expect(aggregation.Buckets[0].Key).To(Equal(map[string]interface{}{"source": expectedBucketKey}))
+					Expect(aggregation.Buckets[0].DocCount).To(Equal(expectedDocCount))
+				})
+			})
+
+			When("a cardinality aggregation is used", func() {
+				BeforeEach(func() {
+					expectedSearch.Aggregations = map[string]*EsAggregation{
+						expectedAggregation: {
+							Cardinality: &EsCardinalityAggregation{
+								Field: expectedField,
+							},
+						},
+					}
+
+					transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+						"hits": {"total": {"value": 0}, "hits": []},
+						"aggregations": {
+							"%[1]s": {"value": %[2]d}
+						}
+					}`, expectedAggregation, expectedDocCount)))
+				})
+
+				It("should send the cardinality aggregation", func() {
+					searchRequest := &EsSearch{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest.Aggregations[expectedAggregation].Cardinality.Field).To(Equal(expectedField))
+				})
+
+				It("should decode the value", func() {
+					aggregation := actualSearchResponse.Aggregations[expectedAggregation]
+
+					Expect(aggregation.Value).ToNot(BeNil())
+					Expect(*aggregation.Value).To(BeNumerically("==", expectedDocCount))
+					Expect(aggregation.Aggregations).To(BeEmpty())
+				})
+			})
+
+			When("a filters aggregation is used", func() {
+				BeforeEach(func() {
+					expectedSearch.Aggregations = map[string]*EsAggregation{
+						expectedAggregation: {
+							Filters: &EsFiltersAggregation{
+								Filters: map[string]*filtering.Query{
+									"first": {
+										Term: &filtering.Term{
+											expectedField: expectedBucketKey,
+										},
+									},
+									"second": {
+										Prefix: &filtering.Term{
+											expectedField: expectedBucketKey,
+										},
+									},
+								},
+							},
+							Aggregations: map[string]*EsAggregation{
+								"unique": {
+									Cardinality: &EsCardinalityAggregation{
+										Field: expectedField,
+									},
+								},
+							},
+						},
+					}
+
+					transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+						"hits": {"total": {"value": 0}, "hits": []},
+						"aggregations": {
+							"%[1]s": {
+								"buckets": {
+									"first": {"doc_count": %[2]d, "unique": {"value": 1}},
+									"second": {"doc_count": 0, "unique": {"value": 0}}
+								}
+							}
+						}
+					}`, expectedAggregation, expectedDocCount)))
+				})
+
+				It("should send the filters aggregation", func() {
+					searchRequest := &EsSearch{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest.Aggregations).To(BeEquivalentTo(expectedSearch.Aggregations))
+				})
+
+				It("should decode the keyed buckets", func() {
+					aggregation := actualSearchResponse.Aggregations[expectedAggregation]
+
+					Expect(aggregation.Buckets).To(BeEmpty())
+					Expect(aggregation.KeyedBuckets).To(HaveLen(2))
+					Expect(aggregation.KeyedBuckets["first"].DocCount).To(Equal(expectedDocCount))
+					Expect(*aggregation.KeyedBuckets["first"].Aggregations["unique"].Value).To(BeNumerically("==", 1))
+					Expect(aggregation.KeyedBuckets["second"].DocCount).To(Equal(0))
+				})
+			})
+
+			When("a nested aggregation is used", func() {
+				BeforeEach(func() {
+					expectedSearch.Aggregations = map[string]*EsAggregation{
+						expectedAggregation: {
+							Nested: &EsNestedAggregation{
+								Path: expectedField,
+							},
+							Aggregations: map[string]*EsAggregation{
+								"inner": {
+									Terms: &EsTermsAggregation{
+										Field: fmt.Sprintf("%s.%s", expectedField, fake.LetterN(10)),
+									},
+								},
+							},
+						},
+					}
+
+					transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+						"hits": {"total": {"value": 0}, "hits": []},
+						"aggregations": {
+							"%[1]s": {
+								"doc_count": %[2]d,
+								"inner": {
+									"buckets": [{"key": "%[3]s", "doc_count": %[2]d}]
+								}
+							}
+						}
+					}`, expectedAggregation, expectedDocCount, expectedBucketKey)))
+				})
+
+				It("should send the nested aggregation", func() {
+					searchRequest := &EsSearch{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest.Aggregations).To(BeEquivalentTo(expectedSearch.Aggregations))
+				})
+
+				It("should decode the nested sub-aggregations", func() {
+					aggregation := actualSearchResponse.Aggregations[expectedAggregation]
+
+					Expect(aggregation.DocCount).To(Equal(expectedDocCount))
+					Expect(aggregation.Aggregations["inner"].Buckets).To(HaveLen(1))
+					Expect(aggregation.Aggregations["inner"].Buckets[0].Key).To(Equal(expectedBucketKey))
+				})
+			})
+
+			When("a top_hits aggregation is used", func() {
+				var expectedId string
+
+				BeforeEach(func() {
+					expectedId = fake.LetterN(10)
+					expectedSearch.Aggregations[expectedAggregation].Aggregations = map[string]*EsAggregation{
+						"latest": {
+							TopHits: &EsTopHitsAggregation{
+								Size: 1,
+								Sort: map[string]EsSortOrder{
+									"createTime": EsSortOrderDescending,
+								},
+							},
+						},
+					}
+
+					transport.PreparedHttpResponses[0].Body = io.NopCloser(strings.NewReader(fmt.Sprintf(`{
+						"hits": {"total": {"value": 0}, "hits": []},
+						"aggregations": {
+							"%[1]s": {
+								"buckets": [
+									{
+										"key": "%[2]s",
+										"doc_count": 1,
+										"latest": {
+											"hits": {
+												"total": {"value": 1},
+												"hits": [{"_id": "%[3]s", "_source": {"name": "%[3]s"}}]
+											}
+										}
+									}
+								]
+							}
+						}
+					}`, expectedAggregation, expectedBucketKey, expectedId)))
+				})
+
+				It("should send the top_hits aggregation", func() {
+					searchRequest := &EsSearch{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest.Aggregations).To(BeEquivalentTo(expectedSearch.Aggregations))
+				})
+
+				It("should decode the hits", func() {
+					latest := actualSearchResponse.Aggregations[expectedAggregation].Buckets[0].Aggregations["latest"]
+
+					Expect(latest.Aggregations).To(BeEmpty())
+					Expect(latest.Hits.Total.Value).To(Equal(1))
+					Expect(latest.Hits.Hits).To(HaveLen(1))
+					Expect(latest.Hits.Hits[0].ID).To(Equal(expectedId))
+					Expect(latest.Hits.Hits[0].Source).To(MatchJSON(fmt.Sprintf(`{"name": "%s"}`, expectedId)))
+				})
+			})
+		})
+
 		When("the search operation fails", func() {
 			BeforeEach(func() {
 				transport.PreparedHttpResponses[0] = &http.Response{
@@ -596,25 +991,57 @@ var _ = Describe("elasticsearch client", func() {
 
 		When("pagination is used", func() {
 			var (
-				expectedPageSize int
-				expectedPitId    string
+				expectedPageSize   int
+				expectedPitId      string
+				expectedSortValues []json.RawMessage
 			)
 
 			BeforeEach(func() {
-				expectedPageSize = fake.Number(20, 100)
+				expectedPageSize = fake.Number(2, 5)
 				expectedPitId = fake.LetterN(10)
+				// larger than the biggest integer a float64 can represent exactly
+				expectedSortValues = []json.RawMessage{
+					json.RawMessage("18014398509481985"),
+					json.RawMessage(fmt.Sprintf(`"%s"`, fake.LetterN(10))),
+				}
+				expectedSearchRequest.Search = &EsSearch{
+					Sort: []EsSortField{
+						{Field: "createTime", Order: EsSortOrderDescending},
+						{Field: "name", Order: EsSortOrderAscending},
+					},
+				}
 				expectedSearchRequest.Pagination = &SearchPaginationOptions{
 					Size: expectedPageSize,
 				}
 
-				transport.PreparedHttpResponses = append([]*http.Response{
+				// there's one more hit than the page size, so there's another page
+				expectedSearchResponse.Hits.Hits = nil
+				for i := 0; i <= expectedPageSize; i++ {
+					expectedSearchResponse.Hits.Hits = append(expectedSearchResponse.Hits.Hits, &EsSearchResponseHit{
+						ID:     fake.LetterN(10),
+						Source: []byte("{}"),
+						Sort:   []json.RawMessage{json.RawMessage(strconv.Itoa(i)), json.RawMessage(`"a"`)},
+					})
+				}
+				expectedSearchResponse.Hits.Hits[expectedPageSize-1].Sort = expectedSortValues
+
+				transport.PreparedHttpResponses = []*http.Response{
 					{
 						StatusCode: http.StatusOK,
 						Body: structToJsonBody(&ESPitResponse{
 							Id: expectedPitId,
 						}),
 					},
-				}, transport.PreparedHttpResponses...)
+					{
+						StatusCode: http.StatusOK,
+						Body:       structToJsonBody(expectedSearchResponse),
+					},
+					// closing the PIT, when that's expected
+					{
+						StatusCode: http.StatusOK,
+						Body:       structToJsonBody(map[string]interface{}{"succeeded": true}),
+					},
+				}
 			})
 
 			When("a page token is not specified", func() {
@@ -627,20 +1054,178 @@ var _ = Describe("elasticsearch client", func() {
 				It("should perform a search using the PIT id", func() {
 					Expect(transport.ReceivedHttpRequests[1].URL.Path).To(Equal("/_search"))
 					Expect(transport.ReceivedHttpRequests[1].Method).To(Equal(http.MethodGet))
-					Expect(transport.ReceivedHttpRequests[1].URL.Query().Get("size")).To(Equal(strconv.Itoa(expectedPageSize)))
-					Expect(transport.ReceivedHttpRequests[1].URL.Query().Get("from")).To(Equal(strconv.Itoa(0)))
+					Expect(transport.ReceivedHttpRequests[1].URL.Query().Get("size")).To(Equal(strconv.Itoa(expectedPageSize + 1)))
+					Expect(transport.ReceivedHttpRequests[1].URL.Query().Has("from")).To(BeFalse())
 
 					searchRequest := &EsSearch{}
 					ReadRequestBody(transport.ReceivedHttpRequests[1], &searchRequest)
 
 					Expect(searchRequest.Pit.Id).To(Equal(expectedPitId))
+					Expect(searchRequest.Sort).To(Equal(expectedSearchRequest.Search.Sort))
+					Expect(searchRequest.SearchAfter).To(BeEmpty())
+				})
+
+				It("should not close the PIT while there are more pages", func() {
+					Expect(transport.ReceivedHttpRequests).To(HaveLen(2))
+				})
+
+				It("should only return a page of hits", func() {
+					Expect(actualErr).ToNot(HaveOccurred())
+					Expect(actualSearchResponse.Hits.Hits).To(HaveLen(expectedPageSize))
+					Expect(actualSearchResponse.Hits.Hits[expectedPageSize-1].Sort).To(Equal(expectedSortValues))
+				})
+
+				It("should return a next page token that starts after the last hit", func() {
+					Expect(actualErr).ToNot(HaveOccurred())
+
+					pageToken, err := ParsePageToken([]byte(tokenKey), actualSearchResponse.NextPageToken)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(pageToken.Cursor.PitId).To(Equal(expectedPitId))
+					Expect(pageToken.Cursor.SearchAfter).To(Equal(expectedSortValues))
 				})
 
-				It("should return the next page token", func() {
-					pitId, from, err := ParsePageToken(actualSearchResponse.NextPageToken)
+				It("should bind the next page token to the search", func() {
+					expectedQueryHash, err := HashQuery(expectedSearchRequest.Search)
 					Expect(err).ToNot(HaveOccurred())
-					Expect(pitId).To(Equal(expectedPitId))
-					Expect(from).To(BeEquivalentTo(expectedPageSize))
+
+					pageToken, err := ParsePageToken([]byte(tokenKey), actualSearchResponse.NextPageToken)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(pageToken.Index).To(Equal(expectedIndex))
+					Expect(pageToken.QueryHash).To(Equal(expectedQueryHash))
+				})
+
+				When("there's no page token key", func() {
+					BeforeEach(func() {
+						esConfig.Pagination.TokenKey = ""
+					})
+
+					It("should sign the next page token with a random key", func() {
+						Expect(actualErr).ToNot(HaveOccurred())
+						Expect(strings.Split(actualSearchResponse.NextPageToken, ".")).To(HaveLen(2))
+
+						_, err := ParsePageToken([]byte(tokenKey), actualSearchResponse.NextPageToken)
+						Expect(errors.Is(err, ErrInvalidPageToken)).To(BeTrue())
+					})
+
+					It("should reject the next page token when its PIT id has been modified", func() {
+						Expect(actualErr).ToNot(HaveOccurred())
+
+						parts := strings.Split(actualSearchResponse.NextPageToken, ".")
+						payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+						Expect(err).ToNot(HaveOccurred())
+						payload = bytes.Replace(payload, []byte(expectedPitId), []byte(fake.LetterN(10)), 1)
+
+						expectedSearchRequest.Pagination.Token = base64.RawURLEncoding.EncodeToString(payload) + "." + parts[1]
+						response, err := client.Search(ctx, expectedSearchRequest)
+
+						Expect(response).To(BeNil())
+						Expect(errors.Is(err, ErrInvalidPageToken)).To(BeTrue())
+						Expect(transport.ReceivedHttpRequests).To(HaveLen(2))
+					})
+				})
+
+				When("elasticsearch returns an updated PIT id", func() {
+					var expectedUpdatedPitId string
+
+					BeforeEach(func() {
+						expectedUpdatedPitId = fake.LetterN(10)
+						transport.PreparedHttpResponses[1].Body = structToJsonBody(&EsSearchResponse{
+							Hits:  expectedSearchResponse.Hits,
+							PitId: expectedUpdatedPitId,
+						})
+					})
+
+					It("should use the updated PIT id in the next page token", func() {
+						pageToken, err := ParsePageToken([]byte(tokenKey), actualSearchResponse.NextPageToken)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(pageToken.Cursor.PitId).To(Equal(expectedUpdatedPitId))
+					})
+				})
+
+				When("a keepalive is specified", func() {
+					var expectedKeepalive string
+
+					BeforeEach(func() {
+						expectedKeepalive = fmt.Sprintf("%ds", fake.Number(1, 60))
+						expectedSearchRequest.Pagination.Keepalive = expectedKeepalive
+					})
+
+					It("should keep the PIT alive for that long", func() {
+						Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("keep_alive")).To(Equal(expectedKeepalive))
+
+						searchRequest := &EsSearch{}
+						ReadRequestBody(transport.ReceivedHttpRequests[1], &searchRequest)
+
+						Expect(searchRequest.Pit.KeepAlive).To(Equal(expectedKeepalive))
+					})
+				})
+
+				When("the keepalive is invalid", func() {
+					BeforeEach(func() {
+						expectedSearchRequest.Pagination.Keepalive = fake.LetterN(10)
+					})
+
+					It("should return an error without sending a request to ES", func() {
+						Expect(actualSearchResponse).To(BeNil())
+						Expect(actualErr).To(HaveOccurred())
+						Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+					})
+				})
+
+				When("the maximum number of PITs are open", func() {
+					var secondSearchErr error
+
+					BeforeEach(func() {
+						esConfig.Pagination.MaxOpenPits = 1
+					})
+
+					JustBeforeEach(func() {
+						_, secondSearchErr = client.Search(ctx, &SearchRequest{
+							Index: expectedIndex,
+							Search: &EsSearch{
+								Sort: expectedSearchRequest.Search.Sort,
+							},
+							Pagination: &SearchPaginationOptions{
+								Size: expectedPageSize,
+							},
+						})
+					})
+
+					It("should not open another PIT", func() {
+						Expect(actualErr).ToNot(HaveOccurred())
+						Expect(errors.Is(secondSearchErr, ErrTooManyOpenPits)).To(BeTrue())
+						Expect(transport.ReceivedHttpRequests).To(HaveLen(2))
+					})
+
+					When("the open PIT is closed", func() {
+						BeforeEach(func() {
+							expectedSearchResponse.Hits.Hits = expectedSearchResponse.Hits.Hits[:expectedPageSize]
+							transport.PreparedHttpResponses[1].Body = structToJsonBody(expectedSearchResponse)
+							transport.PreparedHttpResponses = append(transport.PreparedHttpResponses,
+								&http.Response{
+									StatusCode: http.StatusOK,
+									Body: structToJsonBody(&ESPitResponse{
+										Id: fake.LetterN(10),
+									}),
+								},
+								&http.Response{
+									StatusCode: http.StatusOK,
+									Body: structToJsonBody(&EsSearchResponse{
+										Hits: &EsSearchResponseHits{},
+									}),
+								},
+								&http.Response{
+									StatusCode: http.StatusOK,
+									Body:       structToJsonBody(map[string]interface{}{"succeeded": true}),
+								},
+							)
+						})
+
+						It("should allow another PIT to be opened", func() {
+							Expect(secondSearchErr).ToNot(HaveOccurred())
+							Expect(transport.ReceivedHttpRequests[3].URL.Path).To(Equal(fmt.Sprintf("/%s/_pit", expectedIndex)))
+						})
+					})
 				})
 
 				When("creating the PIT fails", func() {
@@ -667,51 +1252,123 @@ var _ = Describe("elasticsearch client", func() {
 						Expect(actualSearchResponse).To(BeNil())
 						Expect(actualErr).To(HaveOccurred())
 					})
+
+					It("should close the PIT", func() {
+						assertPitClosed(transport.ReceivedHttpRequests[2], expectedPitId)
+					})
 				})
 
 				When("the end of the search results has been reached", func() {
 					BeforeEach(func() {
-						expectedSearchResponse.Hits.Total.Value = fake.Number(0, expectedPageSize-1)
+						expectedSearchResponse.Hits.Hits = expectedSearchResponse.Hits.Hits[:expectedPageSize]
 						transport.PreparedHttpResponses[1].Body = structToJsonBody(expectedSearchResponse)
 					})
 
 					It("should return an empty next page token", func() {
+						Expect(actualErr).ToNot(HaveOccurred())
+						Expect(actualSearchResponse.Hits.Hits).To(HaveLen(expectedPageSize))
 						Expect(actualSearchResponse.NextPageToken).To(BeEmpty())
 					})
+
+					It("should close the PIT", func() {
+						assertPitClosed(transport.ReceivedHttpRequests[2], expectedPitId)
+					})
+
+					When("closing the PIT fails", func() {
+						BeforeEach(func() {
+							transport.PreparedHttpResponses[2] = &http.Response{
+								StatusCode: http.StatusInternalServerError,
+								Body:       structToJsonBody(map[string]interface{}{}),
+							}
+						})
+
+						It("should still return the search results", func() {
+							Expect(actualErr).ToNot(HaveOccurred())
+							Expect(actualSearchResponse.Hits.Hits).To(HaveLen(expectedPageSize))
+						})
+					})
 				})
 			})
 
 			When("a page token is specified", func() {
 				var (
-					expectedFrom int
+					expectedSearchAfter []json.RawMessage
+					expectedQueryHash   string
 				)
 
 				BeforeEach(func() {
-					expectedFrom = fake.Number(10, 20)
-					expectedSearchRequest.Pagination.Token = CreatePageToken(expectedPitId, expectedFrom)
-
-					// we only expect one ES response now for the search operation
-					transport.PreparedHttpResponses = []*http.Response{
-						transport.PreparedHttpResponses[1],
+					expectedSearchAfter = []json.RawMessage{
+						json.RawMessage(strconv.Itoa(fake.Number(1000, 10000))),
+						json.RawMessage(fmt.Sprintf(`"%s"`, fake.LetterN(10))),
 					}
+
+					var err error
+					expectedQueryHash, err = HashQuery(expectedSearchRequest.Search)
+					Expect(err).ToNot(HaveOccurred())
+
+					expectedSearchRequest.Pagination.Token, err = CreatePageToken([]byte(tokenKey), expectedIndex, expectedQueryHash, Cursor{
+						PitId:       expectedPitId,
+						SearchAfter: expectedSearchAfter,
+					})
+					Expect(err).ToNot(HaveOccurred())
+
+					// we only expect ES responses now for the search operation, and closing the PIT
+					transport.PreparedHttpResponses = transport.PreparedHttpResponses[1:]
 				})
 
-				It("should perform a search using the provided PIT", func() {
+				It("should continue the search after the previous page using the provided PIT", func() {
 					Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_search"))
 					Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodGet))
-					Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("size")).To(Equal(strconv.Itoa(expectedPageSize)))
-					Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("from")).To(Equal(strconv.Itoa(expectedFrom)))
+					Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("size")).To(Equal(strconv.Itoa(expectedPageSize + 1)))
+					Expect(transport.ReceivedHttpRequests[0].URL.Query().Has("from")).To(BeFalse())
+
+					searchRequest := &EsSearch{}
+					ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+					Expect(searchRequest.Pit.Id).To(Equal(expectedPitId))
+					Expect(searchRequest.SearchAfter).To(Equal(expectedSearchAfter))
 				})
 
 				When("the end of the search results has been reached", func() {
 					BeforeEach(func() {
-						expectedSearchResponse.Hits.Total.Value = fake.Number(0, expectedFrom+expectedPageSize-1)
+						expectedSearchResponse.Hits.Hits = nil
 						transport.PreparedHttpResponses[0].Body = structToJsonBody(expectedSearchResponse)
 					})
 
 					It("should return an empty next page token", func() {
+						Expect(actualErr).ToNot(HaveOccurred())
 						Expect(actualSearchResponse.NextPageToken).To(BeEmpty())
 					})
+
+					It("should close the PIT", func() {
+						assertPitClosed(transport.ReceivedHttpRequests[1], expectedPitId)
+					})
+				})
+
+				When("the search fails with a transient error", func() {
+					BeforeEach(func() {
+						transport.PreparedHttpResponses[0] = &http.Response{
+							StatusCode: http.StatusServiceUnavailable,
+						}
+					})
+
+					It("should keep the PIT, so that the page token can be retried", func() {
+						Expect(actualErr).To(HaveOccurred())
+						Expect(transport.ReceivedHttpRequests).To(HaveLen(1))
+					})
+				})
+
+				When("the search fails because the request is invalid", func() {
+					BeforeEach(func() {
+						transport.PreparedHttpResponses[0] = &http.Response{
+							StatusCode: http.StatusBadRequest,
+						}
+					})
+
+					It("should close the PIT", func() {
+						Expect(actualErr).To(HaveOccurred())
+						assertPitClosed(transport.ReceivedHttpRequests[1], expectedPitId)
+					})
 				})
 
 				When("the provided page token is invalid", func() {
@@ -721,10 +1378,88 @@ var _ = Describe("elasticsearch client", func() {
 
 					It("should return an error", func() {
 						Expect(actualSearchResponse).To(BeNil())
-						Expect(actualErr).To(HaveOccurred())
+						Expect(errors.Is(actualErr, ErrInvalidPageToken)).To(BeTrue())
+					})
+
+					It("should not send a request to ES", func() {
+						Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+					})
+				})
+
+				When("the page token was signed with a different key", func() {
+					BeforeEach(func() {
+						var err error
+						expectedSearchRequest.Pagination.Token, err = CreatePageToken([]byte(fake.LetterN(32)), expectedIndex, expectedQueryHash, Cursor{
+							PitId:       expectedPitId,
+							SearchAfter: expectedSearchAfter,
+						})
+						Expect(err).ToNot(HaveOccurred())
+					})
+
+					It("should return an error without sending a request to ES", func() {
+						Expect(actualSearchResponse).To(BeNil())
+						Expect(errors.Is(actualErr, ErrInvalidPageToken)).To(BeTrue())
+						Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+					})
+				})
+
+				When("the page token has been modified", func() {
+					BeforeEach(func() {
+						parts := strings.Split(expectedSearchRequest.Pagination.Token, ".")
+						payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+						Expect(err).ToNot(HaveOccurred())
+
+						payload = bytes.Replace(payload, []byte(expectedPitId), []byte(fake.LetterN(10)), 1)
+						expectedSearchRequest.Pagination.Token = base64.RawURLEncoding.EncodeToString(payload) + "." + parts[1]
+					})
+
+					It("should return an error without sending a request to ES", func() {
+						Expect(actualSearchResponse).To(BeNil())
+						Expect(errors.Is(actualErr, ErrInvalidPageToken)).To(BeTrue())
+						Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+					})
+				})
+
+				When("the page token was created for a different index", func() {
+					BeforeEach(func() {
+						expectedSearchRequest.Index = fake.LetterN(10)
+					})
+
+					It("should return an error without sending a request to ES", func() {
+						Expect(actualSearchResponse).To(BeNil())
+						Expect(errors.Is(actualErr, ErrInvalidPageToken)).To(BeTrue())
+						Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+					})
+				})
+
+				When("the page token was created for a different query", func() {
+					BeforeEach(func() {
+						expectedSearchRequest.Search.Query = &filtering.Query{
+							Term: &filtering.Term{
+								fake.LetterN(10): fake.LetterN(10),
+							},
+						}
+					})
+
+					It("should return an error without sending a request to ES", func() {
+						Expect(actualSearchResponse).To(BeNil())
+						Expect(errors.Is(actualErr, ErrInvalidPageToken)).To(BeTrue())
+						Expect(transport.ReceivedHttpRequests).To(BeEmpty())
 					})
 				})
 			})
+
+			When("the search is not sorted", func() {
+				BeforeEach(func() {
+					expectedSearchRequest.Search.Sort = nil
+				})
+
+				It("should return an error without sending a request to ES", func() {
+					Expect(actualSearchResponse).To(BeNil())
+					Expect(actualErr).To(MatchError(ContainSubstring("must be sorted")))
+					Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+				})
+			})
 		})
 	})
 
@@ -862,6 +1597,66 @@ var _ = Describe("elasticsearch client", func() {
 		})
 	})
 
+	Context("timeouts", func() {
+		var (
+			actualDeadline    time.Time
+			actualHasDeadline bool
+		)
+
+		BeforeEach(func() {
+			transport.Actions = []TransportAction{
+				func(req *http.Request) (*http.Response, error) {
+					actualDeadline, actualHasDeadline = req.Context().Deadline()
+
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       structToJsonBody(map[string]interface{}{}),
+					}, nil
+				},
+			}
+		})
+
+		It("should not time out requests by default", func() {
+			_, err := client.Get(ctx, &GetRequest{Index: fake.LetterN(10), DocumentId: fake.LetterN(10)})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actualHasDeadline).To(BeFalse())
+		})
+
+		When("timeouts are configured", func() {
+			BeforeEach(func() {
+				esConfig.Timeouts = config.TimeoutConfig{
+					Request: "1m",
+					Bulk:    "1h",
+				}
+			})
+
+			It("should use the request timeout", func() {
+				_, err := client.Get(ctx, &GetRequest{Index: fake.LetterN(10), DocumentId: fake.LetterN(10)})
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(actualHasDeadline).To(BeTrue())
+				Expect(actualDeadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+			})
+
+			It("should use the bulk timeout for bulk requests", func() {
+				_, err := client.Bulk(ctx, &BulkRequest{
+					Index: fake.LetterN(10),
+					Items: []*BulkRequestItem{
+						{
+							Message:   protov1.MessageV2(createRandomOccurrence()),
+							Operation: BULK_CREATE,
+						},
+					},
+				})
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(actualHasDeadline).To(BeTrue())
+				Expect(actualDeadline).To(BeTemporally("~", time.Now().Add(time.Hour), time.Second))
+			})
+		})
+	})
+
 	Context("Get", func() {
 		var (
 			expectedDocumentId string
@@ -979,6 +1774,83 @@ var _ = Describe("elasticsearch client", func() {
 		})
 	})
 
+	Context("GetIndices", func() {
+		var (
+			expectedPattern string
+			expectedIndex   string
+			expectedAlias   string
+
+			actualIndices map[string]*EsIndex
+			actualErr     error
+		)
+
+		BeforeEach(func() {
+			expectedPattern = fake.LetterN(10) + "-*-occurrences"
+			expectedIndex = fake.LetterN(10)
+			expectedAlias = fake.LetterN(10)
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body: structToJsonBody(map[string]interface{}{
+						expectedIndex: map[string]interface{}{
+							"aliases": map[string]interface{}{
+								expectedAlias: map[string]interface{}{},
+							},
+							"settings": map[string]interface{}{
+								"index": map[string]interface{}{
+									"creation_date": "1630000000000",
+								},
+							},
+						},
+					}),
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualIndices, actualErr = client.GetIndices(ctx, expectedPattern)
+		})
+
+		It("should only request the aliases and creation date of the matching indices", func() {
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodGet))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/" + expectedPattern))
+			Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("filter_path")).To(Equal("*.aliases,*.settings.index.creation_date"))
+		})
+
+		It("should decode the indices", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualIndices).To(HaveLen(1))
+			Expect(actualIndices[expectedIndex].Aliases).To(HaveKey(expectedAlias))
+			Expect(actualIndices[expectedIndex].Settings.Index.CreationDate).To(Equal("1630000000000"))
+		})
+
+		When("no indices match", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].Body = structToJsonBody(map[string]interface{}{})
+			})
+
+			It("should return an empty map", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualIndices).To(BeEmpty())
+			})
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0] = &http.Response{
+					StatusCode: http.StatusInternalServerError,
+					Body:       structToJsonBody(map[string]interface{}{}),
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(actualIndices).To(BeNil())
+			})
+		})
+	})
+
 	Context("MultiGet", func() {
 		var (
 			expectedDocumentIds      []string
@@ -1199,6 +2071,51 @@ var _ = Describe("elasticsearch client", func() {
 				Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("routing")).To(Equal(expectedRouting))
 			})
 		})
+
+		It("should not make the update conditional by default", func() {
+			Expect(transport.ReceivedHttpRequests[0].URL.Query().Has("if_seq_no")).To(BeFalse())
+			Expect(transport.ReceivedHttpRequests[0].URL.Query().Has("if_primary_term")).To(BeFalse())
+		})
+
+		When("a sequence number and primary term are specified", func() {
+			var (
+				expectedSeqNo       int
+				expectedPrimaryTerm int
+			)
+
+			BeforeEach(func() {
+				expectedSeqNo = fake.Number(0, 1000)
+				expectedPrimaryTerm = fake.Number(1, 10)
+				expectedUpdateRequest.IfSeqNo = &expectedSeqNo
+				expectedUpdateRequest.IfPrimaryTerm = &expectedPrimaryTerm
+			})
+
+			It("should only update the document if it has not changed", func() {
+				query := transport.ReceivedHttpRequests[0].URL.Query()
+
+				Expect(query.Get("if_seq_no")).To(Equal(strconv.Itoa(expectedSeqNo)))
+				Expect(query.Get("if_primary_term")).To(Equal(strconv.Itoa(expectedPrimaryTerm)))
+			})
+
+			When("the document has changed", func() {
+				BeforeEach(func() {
+					transport.PreparedHttpResponses[0] = &http.Response{
+						StatusCode: http.StatusConflict,
+						Body: structToJsonBody(&EsIndexDocResponse{
+							Error: &EsIndexDocError{
+								Type:   "version_conflict_engine_exception",
+								Reason: fake.LetterN(10),
+							},
+						}),
+					}
+				})
+
+				It("should return a version conflict error", func() {
+					Expect(actualErr).To(MatchError(ErrVersionConflict))
+					Expect(actualResponse).To(BeNil())
+				})
+			})
+		})
 	})
 
 	Context("Delete", func() {
@@ -1285,8 +2202,8 @@ var _ = Describe("elasticsearch client", func() {
 				})
 			})
 
-			It("should return an error", func() {
-				Expect(actualErr).To(HaveOccurred())
+			It("should return a not found error", func() {
+				Expect(actualErr).To(MatchError(ErrNotFound))
 			})
 		})
 
@@ -1335,8 +2252,8 @@ func createRandomSearch() *EsSearch {
 				fake.LetterN(10): fake.LetterN(10),
 			},
 		},
-		Sort: map[string]EsSortOrder{
-			fake.LetterN(10): EsSortOrderDescending,
+		Sort: []EsSortField{
+			{Field: fake.LetterN(10), Order: EsSortOrderDescending},
 		},
 		Collapse: &EsSearchCollapse{
 			Field: fake.LetterN(10),
@@ -1362,6 +2279,15 @@ func createRandomDocumentIds(l int) []string {
 	return result
 }
 
+func assertPitClosed(request *http.Request, expectedPitId string) {
+	Expect(request.URL.Path).To(Equal("/_pit"))
+	Expect(request.Method).To(Equal(http.MethodDelete))
+
+	closePitRequest := &EsClosePitRequest{}
+	ReadRequestBody(request, closePitRequest)
+	Expect(closePitRequest.Id).To(Equal(expectedPitId))
+}
+
 func structToJsonBody(i interface{}) io.ReadCloser {
 	b, err := json.Marshal(i)
 	Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,177 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package esutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"go.uber.org/zap"
+)
+
+// MinimumVersion is the oldest version of Elasticsearch that's supported, as it introduced the point in time API used for pagination
+var MinimumVersion = Version{Major: 7, Minor: 10}
+
+// ErrUnsupportedVersion is returned when the cluster is older than MinimumVersion
+var ErrUnsupportedVersion = errors.New("unsupported elasticsearch version")
+
+// ErrClusterRejected is returned when Elasticsearch responds with an error that retrying won't fix, such as invalid
+// credentials or the wrong URL
+var ErrClusterRejected = errors.New("elasticsearch rejected the request")
+
+// healthCheckTimeout is how long Elasticsearch waits for the cluster to become yellow, during each health check
+const healthCheckTimeout = 10 * time.Second
+
+type Version struct {
+	Major, Minor, Patch int
+}
+
+var versionPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// ParseVersion parses an Elasticsearch version number, such as 7.10.0 or 8.0.0-SNAPSHOT
+func ParseVersion(version string) (*Version, error) {
+	match := versionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return nil, fmt.Errorf("unable to parse elasticsearch version %q", version)
+	}
+
+	var parts [3]int
+	for i := range parts {
+		part, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse elasticsearch version %q: %s", version, err)
+		}
+		parts[i] = part
+	}
+
+	return &Version{
+		Major: parts[0],
+		Minor: parts[1],
+		Patch: parts[2],
+	}, nil
+}
+
+// Less returns true if the version is older than other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+
+	return v.Patch < other.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// WaitForCluster waits until Elasticsearch is reachable and the cluster status is at least yellow, then returns its version.
+// Attempts are spaced out with the retry backoff, until the startup wait timeout has passed.
+// Clusters older than MinimumVersion, and requests that Elasticsearch rejects, fail straight away.
+func WaitForCluster(ctx context.Context, logger *zap.Logger, esClient *elasticsearch.Client, c *config.ElasticsearchConfig) (*Version, error) {
+	log := logger.Named("WaitForCluster")
+
+	timeout := c.Startup.Timeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		version, err := checkCluster(ctx, esClient)
+		if err == nil {
+			return version, nil
+		}
+
+		if errors.Is(err, ErrUnsupportedVersion) || errors.Is(err, ErrClusterRejected) {
+			return nil, err
+		}
+
+		backoff := c.Retry.Backoff(attempt)
+		log.Info("waiting for elasticsearch to be ready", zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("elasticsearch was not ready after %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// checkCluster returns the version of the cluster, if it's supported and ready to use
+func checkCluster(ctx context.Context, esClient *elasticsearch.Client) (*Version, error) {
+	res, err := esClient.Info(esClient.Info.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, clusterResponseError(res)
+	}
+
+	var info EsInfoResponse
+	if err := DecodeResponse(res.Body, &info); err != nil {
+		return nil, err
+	}
+
+	version, err := ParseVersion(info.Version.Number)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, err)
+	}
+
+	if version.Less(MinimumVersion) {
+		return nil, fmt.Errorf("%w: found %s, but %s or later is required", ErrUnsupportedVersion, version, MinimumVersion)
+	}
+
+	res, err = esClient.Cluster.Health(
+		esClient.Cluster.Health.WithContext(ctx),
+		esClient.Cluster.Health.WithWaitForStatus(ClusterHealthYellow),
+		esClient.Cluster.Health.WithTimeout(healthCheckTimeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, clusterResponseError(res)
+	}
+
+	var health EsClusterHealthResponse
+	if err := DecodeResponse(res.Body, &health); err != nil {
+		return nil, err
+	}
+
+	if health.Status != ClusterHealthGreen && health.Status != ClusterHealthYellow {
+		return nil, fmt.Errorf("cluster status is %s", health.Status)
+	}
+
+	return version, nil
+}
+
+// clusterResponseError marks errors that aren't worth retrying, such as 401, 403 and 404 responses, with ErrClusterRejected
+func clusterResponseError(res *esapi.Response) error {
+	err := newResponseError(res)
+	if isTransient(err) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ErrClusterRejected, err)
+}
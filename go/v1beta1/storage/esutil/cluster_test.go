@@ -0,0 +1,259 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package esutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+)
+
+var _ = Describe("cluster", func() {
+	DescribeTable("ParseVersion", func(version string, expected *Version) {
+		actual, err := ParseVersion(version)
+
+		if expected == nil {
+			Expect(err).To(HaveOccurred())
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actual).To(Equal(expected))
+		}
+	},
+		Entry("release", "7.10.2", &Version{Major: 7, Minor: 10, Patch: 2}),
+		Entry("snapshot", "8.0.0-SNAPSHOT", &Version{Major: 8}),
+		Entry("missing patch", "7.10", nil),
+		Entry("empty", "", nil),
+		Entry("not a version", "latest", nil),
+	)
+
+	DescribeTable("Version.Less", func(version, other Version, expected bool) {
+		Expect(version.Less(other)).To(Equal(expected))
+	},
+		Entry("older major", Version{Major: 6, Minor: 20}, Version{Major: 7, Minor: 10}, true),
+		Entry("older minor", Version{Major: 7, Minor: 9, Patch: 3}, Version{Major: 7, Minor: 10}, true),
+		Entry("older patch", Version{Major: 7, Minor: 10}, Version{Major: 7, Minor: 10, Patch: 1}, true),
+		Entry("same", Version{Major: 7, Minor: 10}, Version{Major: 7, Minor: 10}, false),
+		Entry("newer", Version{Major: 8}, Version{Major: 7, Minor: 10}, false),
+	)
+
+	Context("WaitForCluster", func() {
+		var (
+			transport *MockEsTransport
+			esConfig  *config.ElasticsearchConfig
+
+			actualVersion *Version
+			actualErr     error
+		)
+
+		infoResponse := func(version string) *http.Response {
+			info := &EsInfoResponse{}
+			info.Version.Number = version
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       structToJsonBody(info),
+			}
+		}
+
+		healthResponse := func(statusCode int, status string) *http.Response {
+			return &http.Response{
+				StatusCode: statusCode,
+				Body:       structToJsonBody(&EsClusterHealthResponse{Status: status}),
+			}
+		}
+
+		unreachable := func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		}
+
+		BeforeEach(func() {
+			transport = &MockEsTransport{}
+			esConfig = &config.ElasticsearchConfig{
+				Retry: config.RetryConfig{
+					InitialBackoff: "1ms",
+					MaxBackoff:     "5ms",
+				},
+				Startup: config.StartupConfig{
+					WaitTimeout: "1s",
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			esClient := &elasticsearch.Client{Transport: transport, API: esapi.New(transport)}
+
+			actualVersion, actualErr = WaitForCluster(context.Background(), logger, esClient, esConfig)
+		})
+
+		When("the cluster is ready", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					infoResponse("7.10.0"),
+					healthResponse(http.StatusOK, ClusterHealthYellow),
+				}
+			})
+
+			It("should return the version", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualVersion).To(Equal(&Version{Major: 7, Minor: 10}))
+			})
+
+			It("should wait for the cluster to be at least yellow", func() {
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(2))
+				Expect(transport.ReceivedHttpRequests[1].URL.Path).To(Equal("/_cluster/health"))
+				Expect(transport.ReceivedHttpRequests[1].URL.Query().Get("wait_for_status")).To(Equal(ClusterHealthYellow))
+			})
+		})
+
+		When("elasticsearch is not reachable at first", func() {
+			BeforeEach(func() {
+				transport.Actions = []TransportAction{unreachable, unreachable}
+				transport.PreparedHttpResponses = []*http.Response{
+					infoResponse("7.12.1"),
+					healthResponse(http.StatusOK, ClusterHealthGreen),
+				}
+			})
+
+			It("should keep trying until it is", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualVersion).To(Equal(&Version{Major: 7, Minor: 12, Patch: 1}))
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(4))
+			})
+		})
+
+		When("the cluster is red at first", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					infoResponse("7.10.0"),
+					healthResponse(http.StatusRequestTimeout, "red"),
+					infoResponse("7.10.0"),
+					healthResponse(http.StatusOK, ClusterHealthYellow),
+				}
+			})
+
+			It("should keep trying until it's yellow", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(4))
+			})
+		})
+
+		When("the credentials are rejected", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					{
+						StatusCode: http.StatusUnauthorized,
+						Body:       structToJsonBody(map[string]interface{}{}),
+					},
+				}
+			})
+
+			It("should return an error without waiting", func() {
+				Expect(actualVersion).To(BeNil())
+				Expect(errors.Is(actualErr, ErrClusterRejected)).To(BeTrue())
+				Expect(actualErr).To(MatchError(ContainSubstring("401")))
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(1))
+			})
+		})
+
+		When("the health check is forbidden", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					infoResponse("7.10.0"),
+					{
+						StatusCode: http.StatusForbidden,
+						Body:       structToJsonBody(map[string]interface{}{}),
+					},
+				}
+			})
+
+			It("should return an error without waiting", func() {
+				Expect(errors.Is(actualErr, ErrClusterRejected)).To(BeTrue())
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(2))
+			})
+		})
+
+		When("elasticsearch is overloaded at first", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					{
+						StatusCode: http.StatusTooManyRequests,
+						Body:       structToJsonBody(map[string]interface{}{}),
+					},
+					infoResponse("7.10.0"),
+					healthResponse(http.StatusOK, ClusterHealthGreen),
+				}
+			})
+
+			It("should keep trying", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(3))
+			})
+		})
+
+		When("elasticsearch never becomes reachable", func() {
+			BeforeEach(func() {
+				esConfig.Startup.WaitTimeout = "20ms"
+				for i := 0; i < 100; i++ {
+					transport.Actions = append(transport.Actions, unreachable)
+				}
+			})
+
+			It("should return an error once the wait timeout has passed", func() {
+				Expect(actualVersion).To(BeNil())
+				Expect(actualErr).To(MatchError(ContainSubstring("not ready after 20ms")))
+				Expect(actualErr).To(MatchError(ContainSubstring("connection refused")))
+			})
+		})
+
+		When("the cluster is older than the minimum version", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					infoResponse("7.9.3"),
+				}
+			})
+
+			It("should return an error without waiting", func() {
+				Expect(actualVersion).To(BeNil())
+				Expect(errors.Is(actualErr, ErrUnsupportedVersion)).To(BeTrue())
+				Expect(actualErr).To(MatchError(ContainSubstring("7.10.0 or later")))
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(1))
+			})
+		})
+
+		When("the version can't be parsed", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses = []*http.Response{
+					{
+						StatusCode: http.StatusOK,
+						Body:       structToJsonBody(map[string]interface{}{"tagline": "You Know, for Search"}),
+					},
+				}
+			})
+
+			It("should return an error without waiting", func() {
+				Expect(actualVersion).To(BeNil())
+				Expect(errors.Is(actualErr, ErrUnsupportedVersion)).To(BeTrue())
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(1))
+			})
+		})
+	})
+})
@@ -0,0 +1,143 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package esutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// Elasticsearch error types that callers may want to handle differently
+const (
+	ErrorTypeIndexNotFound       = "index_not_found_exception"
+	ErrorTypeVersionConflict     = "version_conflict_engine_exception"
+	ErrorTypeRejectedExecution   = "es_rejected_execution_exception"
+	ErrorTypeTimeout             = "timeout_exception"
+	ErrorTypeReceiveTimeout      = "receive_timeout_transport_exception"
+	ErrorTypeClusterEventTimeout = "process_cluster_event_timeout_exception"
+	ErrorTypeParsing             = "parsing_exception"
+	ErrorTypeXContentParse       = "x_content_parse_exception"
+	ErrorTypeJsonParse           = "json_parse_exception"
+	ErrorTypeMapperParsing       = "mapper_parsing_exception"
+	ErrorTypeQueryShard          = "query_shard_exception"
+	ErrorTypeNumberFormat        = "number_format_exception"
+)
+
+// ErrNotFound is returned when no documents matched a request that expects at least one, such as a delete
+var ErrNotFound = errors.New("elasticsearch returned zero matching documents")
+
+// ErrVersionConflict is returned when a conditional write fails because the document has been modified
+var ErrVersionConflict = errors.New("elasticsearch version conflict")
+
+// ErrTooManyOpenPits is returned when a paginated search would open more points in time than the configured limit
+var ErrTooManyOpenPits = errors.New("too many open point in time searches")
+
+// Error is returned when Elasticsearch responds with an error status code
+type Error struct {
+	StatusCode int
+	// Type and Reason are taken from the error object in the response body, when there is one
+	Type   string
+	Reason string
+	// RootCauseTypes contains the types of the root causes and nested causes reported by Elasticsearch
+	RootCauseTypes []string
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("unexpected response from elasticsearch: [%d] %s", e.StatusCode, e.Reason)
+	}
+
+	return fmt.Sprintf("unexpected response from elasticsearch: [%d] %s: %s", e.StatusCode, e.Type, e.Reason)
+}
+
+// Is allows version conflicts to be matched with errors.Is(err, ErrVersionConflict)
+func (e *Error) Is(target error) bool {
+	return target == ErrVersionConflict && e.HasType(ErrorTypeVersionConflict)
+}
+
+// HasType returns true if the error or any of its root causes is one of the given Elasticsearch error types
+func (e *Error) HasType(errorTypes ...string) bool {
+	for _, errorType := range errorTypes {
+		if e.Type == errorType {
+			return true
+		}
+
+		for _, rootCauseType := range e.RootCauseTypes {
+			if rootCauseType == errorType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isTransient returns true for errors that may not happen again if the request is retried, such as network errors,
+// timeouts, and overloaded or unavailable nodes
+func isTransient(err error) bool {
+	var esErr *Error
+	if !errors.As(err, &esErr) {
+		return true
+	}
+
+	if esErr.HasType(ErrorTypeRejectedExecution, ErrorTypeTimeout, ErrorTypeReceiveTimeout, ErrorTypeClusterEventTimeout) {
+		return true
+	}
+
+	return esErr.StatusCode == http.StatusRequestTimeout || esErr.StatusCode == http.StatusTooManyRequests || esErr.StatusCode >= http.StatusInternalServerError
+}
+
+// newResponseError creates an Error from an unsuccessful Elasticsearch response
+func newResponseError(res *esapi.Response) error {
+	esErr := &Error{
+		StatusCode: res.StatusCode,
+	}
+
+	if res.Body == nil {
+		return esErr
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		esErr.Reason = fmt.Sprintf("error reading response body: %s", err)
+		return esErr
+	}
+
+	var errorResponse EsErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Error == nil {
+		esErr.Reason = strings.TrimSpace(string(body))
+		return esErr
+	}
+
+	esErr.Type = errorResponse.Error.Type
+	esErr.Reason = errorResponse.Error.Reason
+	for cause := errorResponse.Error; cause != nil; cause = cause.CausedBy {
+		for _, rootCause := range cause.RootCause {
+			esErr.RootCauseTypes = append(esErr.RootCauseTypes, rootCause.Type)
+		}
+		if cause != errorResponse.Error {
+			esErr.RootCauseTypes = append(esErr.RootCauseTypes, cause.Type)
+		}
+	}
+
+	return esErr
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package esutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("errors", func() {
+	Context("newResponseError", func() {
+		var (
+			response *esapi.Response
+			actual   *Error
+		)
+
+		BeforeEach(func() {
+			response = &esapi.Response{
+				StatusCode: http.StatusBadRequest,
+				Body: io.NopCloser(strings.NewReader(`{
+					"error": {
+						"root_cause": [{"type": "query_shard_exception", "reason": "failed to create query"}],
+						"type": "search_phase_execution_exception",
+						"reason": "all shards failed",
+						"caused_by": {
+							"type": "number_format_exception",
+							"reason": "For input string: \"foo\""
+						}
+					},
+					"status": 400
+				}`)),
+			}
+		})
+
+		JustBeforeEach(func() {
+			err := newResponseError(response)
+
+			Expect(errors.As(err, &actual)).To(BeTrue())
+		})
+
+		It("should include the status code, type, and reason", func() {
+			Expect(actual.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(actual.Type).To(Equal("search_phase_execution_exception"))
+			Expect(actual.Reason).To(Equal("all shards failed"))
+			Expect(actual.Error()).To(ContainSubstring("search_phase_execution_exception: all shards failed"))
+		})
+
+		It("should include the types of the root causes", func() {
+			Expect(actual.RootCauseTypes).To(ConsistOf(ErrorTypeQueryShard, ErrorTypeNumberFormat))
+			Expect(actual.HasType(ErrorTypeQueryShard)).To(BeTrue())
+			Expect(actual.HasType(ErrorTypeNumberFormat)).To(BeTrue())
+			Expect(actual.HasType(ErrorTypeIndexNotFound)).To(BeFalse())
+		})
+
+		It("should not be a version conflict", func() {
+			Expect(errors.Is(actual, ErrVersionConflict)).To(BeFalse())
+		})
+
+		When("the response is a version conflict", func() {
+			BeforeEach(func() {
+				response = &esapi.Response{
+					StatusCode: http.StatusConflict,
+					Body: io.NopCloser(strings.NewReader(`{
+						"error": {
+							"type": "version_conflict_engine_exception",
+							"reason": "[abc]: version conflict, required seqNo [1], primary term [1]. current document has seqNo [2] and primary term [1]"
+						},
+						"status": 409
+					}`)),
+				}
+			})
+
+			It("should match ErrVersionConflict", func() {
+				Expect(errors.Is(actual, ErrVersionConflict)).To(BeTrue())
+			})
+		})
+
+		When("the response body does not contain an error object", func() {
+			BeforeEach(func() {
+				response = &esapi.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Body:       io.NopCloser(strings.NewReader("too many requests\n")),
+				}
+			})
+
+			It("should use the body as the reason", func() {
+				Expect(actual.StatusCode).To(Equal(http.StatusTooManyRequests))
+				Expect(actual.Type).To(BeEmpty())
+				Expect(actual.Reason).To(Equal("too many requests"))
+			})
+		})
+
+		When("the response has no body", func() {
+			BeforeEach(func() {
+				response = &esapi.Response{
+					StatusCode: http.StatusGatewayTimeout,
+				}
+			})
+
+			It("should only include the status code", func() {
+				Expect(actual.StatusCode).To(Equal(http.StatusGatewayTimeout))
+				Expect(actual.Reason).To(BeEmpty())
+			})
+		})
+	})
+
+	DescribeTable("isTransient", func(err error, expected bool) {
+		Expect(isTransient(err)).To(Equal(expected))
+	},
+		Entry("network error", errors.New("connection refused"), true),
+		Entry("request timeout", &Error{StatusCode: http.StatusRequestTimeout}, true),
+		Entry("too many requests", &Error{StatusCode: http.StatusTooManyRequests}, true),
+		Entry("service unavailable", &Error{StatusCode: http.StatusServiceUnavailable}, true),
+		Entry("rejected execution", &Error{StatusCode: http.StatusBadRequest, RootCauseTypes: []string{ErrorTypeRejectedExecution}}, true),
+		Entry("bad request", &Error{StatusCode: http.StatusBadRequest, Type: ErrorTypeParsing}, false),
+		Entry("unauthorized", &Error{StatusCode: http.StatusUnauthorized}, false),
+		Entry("not found", &Error{StatusCode: http.StatusNotFound}, false),
+	)
+})
@@ -63,6 +63,20 @@ type FakeClient struct {
 		result1 *esutil.EsGetResponse
 		result2 error
 	}
+	GetIndicesStub        func(context.Context, string) (map[string]*esutil.EsIndex, error)
+	getIndicesMutex       sync.RWMutex
+	getIndicesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	getIndicesReturns struct {
+		result1 map[string]*esutil.EsIndex
+		result2 error
+	}
+	getIndicesReturnsOnCall map[int]struct {
+		result1 map[string]*esutil.EsIndex
+		result2 error
+	}
 	MultiGetStub        func(context.Context, *esutil.MultiGetRequest) (*esutil.EsMultiGetResponse, error)
 	multiGetMutex       sync.RWMutex
 	multiGetArgsForCall []struct {
@@ -338,6 +352,8 @@ func (fake *FakeClient) Get(arg1 context.Context, arg2 *esutil.GetRequest) (*esu
 func (fake *FakeClient) GetCallCount() int {
 	fake.getMutex.RLock()
 	defer fake.getMutex.RUnlock()
+	fake.getIndicesMutex.RLock()
+	defer fake.getIndicesMutex.RUnlock()
 	return len(fake.getArgsForCall)
 }
 
@@ -380,6 +396,71 @@ func (fake *FakeClient) GetReturnsOnCall(i int, result1 *esutil.EsGetResponse, r
 	}{result1, result2}
 }
 
+func (fake *FakeClient) GetIndices(arg1 context.Context, arg2 string) (map[string]*esutil.EsIndex, error) {
+	fake.getIndicesMutex.Lock()
+	ret, specificReturn := fake.getIndicesReturnsOnCall[len(fake.getIndicesArgsForCall)]
+	fake.getIndicesArgsForCall = append(fake.getIndicesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetIndicesStub
+	fakeReturns := fake.getIndicesReturns
+	fake.recordInvocation("GetIndices", []interface{}{arg1, arg2})
+	fake.getIndicesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) GetIndicesCallCount() int {
+	fake.getIndicesMutex.RLock()
+	defer fake.getIndicesMutex.RUnlock()
+	return len(fake.getIndicesArgsForCall)
+}
+
+func (fake *FakeClient) GetIndicesCalls(stub func(context.Context, string) (map[string]*esutil.EsIndex, error)) {
+	fake.getIndicesMutex.Lock()
+	defer fake.getIndicesMutex.Unlock()
+	fake.GetIndicesStub = stub
+}
+
+func (fake *FakeClient) GetIndicesArgsForCall(i int) (context.Context, string) {
+	fake.getIndicesMutex.RLock()
+	defer fake.getIndicesMutex.RUnlock()
+	argsForCall := fake.getIndicesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) GetIndicesReturns(result1 map[string]*esutil.EsIndex, result2 error) {
+	fake.getIndicesMutex.Lock()
+	defer fake.getIndicesMutex.Unlock()
+	fake.GetIndicesStub = nil
+	fake.getIndicesReturns = struct {
+		result1 map[string]*esutil.EsIndex
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) GetIndicesReturnsOnCall(i int, result1 map[string]*esutil.EsIndex, result2 error) {
+	fake.getIndicesMutex.Lock()
+	defer fake.getIndicesMutex.Unlock()
+	fake.GetIndicesStub = nil
+	if fake.getIndicesReturnsOnCall == nil {
+		fake.getIndicesReturnsOnCall = make(map[int]struct {
+			result1 map[string]*esutil.EsIndex
+			result2 error
+		})
+	}
+	fake.getIndicesReturnsOnCall[i] = struct {
+		result1 map[string]*esutil.EsIndex
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) MultiGet(arg1 context.Context, arg2 *esutil.MultiGetRequest) (*esutil.EsMultiGetResponse, error) {
 	fake.multiGetMutex.Lock()
 	ret, specificReturn := fake.multiGetReturnsOnCall[len(fake.multiGetArgsForCall)]
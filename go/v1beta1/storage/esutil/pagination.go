@@ -15,29 +15,191 @@
 package esutil
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-const pageTokenSeparator = ":"
+// ErrInvalidPageToken is returned when a page token can't be decoded, wasn't issued by this server,
+// or was issued for a different search
+var ErrInvalidPageToken = errors.New("invalid page token")
 
-func ParsePageToken(pageToken string) (string, int, error) {
-	parts := strings.Split(pageToken, pageTokenSeparator)
+// pageTokenVersion is incremented whenever the contents of page tokens change, so that older tokens are rejected
+// rather than misread
+const pageTokenVersion = 1
 
+const pageTokenSignatureSeparator = "."
+
+// PageToken identifies the next page of a search. It's bound to the index and query that it was created for.
+type PageToken struct {
+	Version   int    `json:"v"`
+	Index     string `json:"index"`
+	QueryHash string `json:"query"`
+	Cursor    Cursor `json:"cursor"`
+}
+
+// Cursor holds the point in time being searched, and the sort values of the last hit on the previous page.
+// Sort values are kept as raw JSON, so that large numbers don't lose precision.
+type Cursor struct {
+	PitId       string            `json:"pitId"`
+	SearchAfter []json.RawMessage `json:"searchAfter"`
+}
+
+// ParsePageToken verifies the signature of a page token created by CreatePageToken with the same key, and decodes it
+func ParsePageToken(key []byte, token string) (*PageToken, error) {
+	parts := strings.Split(token, pageTokenSignatureSeparator)
 	if len(parts) != 2 {
-		return "", 0, errors.New(fmt.Sprintf("error parsing page token, expected two parts split by %s, got %d", pageTokenSeparator, len(parts)))
+		return nil, fmt.Errorf("%w: expected two parts split by %s, got %d", ErrInvalidPageToken, pageTokenSignatureSeparator, len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPageToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPageToken, err)
+	}
+
+	if !hmac.Equal(signature, sign(key, payload)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidPageToken)
+	}
+
+	var t PageToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPageToken, err)
+	}
+
+	if t.Version != pageTokenVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidPageToken, t.Version)
+	}
+
+	if t.Cursor.PitId == "" || len(t.Cursor.SearchAfter) == 0 {
+		return nil, fmt.Errorf("%w: missing PIT id or sort values", ErrInvalidPageToken)
+	}
+
+	return &t, nil
+}
+
+// CreatePageToken returns an opaque token for the page of results that comes after the cursor, signed with the given key
+func CreatePageToken(key []byte, index, queryHash string, cursor Cursor) (string, error) {
+	payload, err := json.Marshal(&PageToken{
+		Version:   pageTokenVersion,
+		Index:     index,
+		QueryHash: queryHash,
+		Cursor:    cursor,
+	})
+	if err != nil {
+		return "", err
 	}
 
-	from, err := strconv.Atoi(parts[1])
+	return base64.RawURLEncoding.EncodeToString(payload) + pageTokenSignatureSeparator + base64.RawURLEncoding.EncodeToString(sign(key, payload)), nil
+}
+
+// HashQuery returns a digest of the parts of a search that determine which hits are returned, and in what order.
+// Page tokens can only be used with a search that has the same hash.
+func HashQuery(search *EsSearch) (string, error) {
+	data, err := json.Marshal(&EsSearch{
+		Query:    search.Query,
+		Sort:     search.Sort,
+		Collapse: search.Collapse,
+	})
 	if err != nil {
-		return "", 0, err
+		return "", err
 	}
 
-	return parts[0], from, nil
+	hash := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
 }
 
-func CreatePageToken(pit string, from int) string {
-	return fmt.Sprintf("%s:%s", pit, strconv.Itoa(from))
+func sign(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// openPits tracks the points in time opened by a client that haven't been closed yet, or expired
+type openPits struct {
+	mu  sync.Mutex
+	max int
+	// pending is the number of points in time that are being opened
+	pending int
+	expiry  map[string]time.Time
+}
+
+func newOpenPits(max int) *openPits {
+	return &openPits{
+		max:    max,
+		expiry: map[string]time.Time{},
+	}
+}
+
+// reserve makes room for a new point in time, and must be followed by either add or release
+func (p *openPits) reserve() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for id, expiry := range p.expiry {
+		if now.After(expiry) {
+			delete(p.expiry, id)
+		}
+	}
+
+	if len(p.expiry)+p.pending >= p.max {
+		return ErrTooManyOpenPits
+	}
+	p.pending++
+
+	return nil
+}
+
+// release gives up a reservation when the point in time couldn't be opened
+func (p *openPits) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending--
+}
+
+// add turns a reservation into an open point in time
+func (p *openPits) add(id string, keepalive time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending--
+	p.expiry[id] = time.Now().Add(keepalive)
+}
+
+// extend is called after each page, as searches keep the point in time alive, and may also change its id.
+// A new id replaces the previous one, so that it stays within the original reservation. Points in time that aren't
+// being tracked, because they were opened by another instance or have already expired, aren't added.
+func (p *openPits) extend(previousId, id string, keepalive time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.expiry[previousId]; !ok {
+		return
+	}
+
+	delete(p.expiry, previousId)
+	p.expiry[id] = time.Now().Add(keepalive)
+}
+
+func (p *openPits) remove(ids ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range ids {
+		delete(p.expiry, id)
+	}
 }
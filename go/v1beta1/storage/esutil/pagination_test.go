@@ -0,0 +1,126 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package esutil
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("openPits", func() {
+	var (
+		pits      *openPits
+		keepalive time.Duration
+	)
+
+	BeforeEach(func() {
+		pits = newOpenPits(2)
+		keepalive = time.Minute
+	})
+
+	It("should not allow more than the maximum number of points in time", func() {
+		Expect(pits.reserve()).To(Succeed())
+		pits.add(fake.LetterN(10), keepalive)
+		Expect(pits.reserve()).To(Succeed())
+
+		Expect(errors.Is(pits.reserve(), ErrTooManyOpenPits)).To(BeTrue())
+	})
+
+	It("should make room when a reservation is released", func() {
+		Expect(pits.reserve()).To(Succeed())
+		Expect(pits.reserve()).To(Succeed())
+		pits.release()
+
+		Expect(pits.reserve()).To(Succeed())
+	})
+
+	It("should make room when a point in time is removed", func() {
+		id := fake.LetterN(10)
+		Expect(pits.reserve()).To(Succeed())
+		pits.add(id, keepalive)
+		Expect(pits.reserve()).To(Succeed())
+		pits.add(fake.LetterN(10), keepalive)
+
+		pits.remove(id)
+
+		Expect(pits.reserve()).To(Succeed())
+	})
+
+	It("should make room when a point in time expires", func() {
+		Expect(pits.reserve()).To(Succeed())
+		pits.add(fake.LetterN(10), -time.Second)
+		Expect(pits.reserve()).To(Succeed())
+
+		Expect(pits.reserve()).To(Succeed())
+	})
+
+	Context("extend", func() {
+		var firstId, secondId string
+
+		BeforeEach(func() {
+			firstId = fake.LetterN(10)
+			secondId = fake.LetterN(10)
+
+			Expect(pits.reserve()).To(Succeed())
+			pits.add(firstId, keepalive)
+			Expect(pits.reserve()).To(Succeed())
+			pits.add(secondId, keepalive)
+		})
+
+		When("elasticsearch rotates the id", func() {
+			var rotatedId string
+
+			BeforeEach(func() {
+				rotatedId = fake.LetterN(10)
+
+				pits.extend(firstId, rotatedId, keepalive)
+			})
+
+			It("should replace the previous id, without using another reservation", func() {
+				Expect(pits.expiry).To(HaveLen(2))
+				Expect(pits.expiry).To(HaveKey(rotatedId))
+				Expect(pits.expiry).ToNot(HaveKey(firstId))
+				Expect(errors.Is(pits.reserve(), ErrTooManyOpenPits)).To(BeTrue())
+			})
+
+			It("should free the reservation when the new id is removed", func() {
+				pits.remove(rotatedId)
+
+				Expect(pits.reserve()).To(Succeed())
+			})
+		})
+
+		It("should extend the keepalive when the id doesn't change", func() {
+			pits.expiry[firstId] = time.Now().Add(time.Second)
+
+			pits.extend(firstId, firstId, keepalive)
+
+			Expect(pits.expiry[firstId]).To(BeTemporally(">", time.Now().Add(keepalive/2)))
+		})
+
+		It("should not track points in time that it doesn't already have", func() {
+			pits.remove(secondId)
+			untrackedId := fake.LetterN(10)
+
+			pits.extend(untrackedId, fake.LetterN(10), keepalive)
+
+			Expect(pits.expiry).To(HaveLen(1))
+			Expect(pits.reserve()).To(Succeed())
+		})
+	})
+})
@@ -16,6 +16,7 @@ package esutil
 
 import (
 	"encoding/json"
+	"fmt"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
@@ -26,9 +27,10 @@ import (
 // Elasticsearch /_search response
 
 type EsSearchResponse struct {
-	Took  int                   `json:"took"`
-	Hits  *EsSearchResponseHits `json:"hits"`
-	PitId string                `json:"pit_id"`
+	Took         int                             `json:"took"`
+	Hits         *EsSearchResponseHits           `json:"hits"`
+	PitId        string                          `json:"pit_id"`
+	Aggregations map[string]*EsAggregationResult `json:"aggregations,omitempty"`
 }
 
 type EsSearchResponseHits struct {
@@ -41,20 +43,66 @@ type EsSearchResponseTotal struct {
 }
 
 type EsSearchResponseHit struct {
-	ID         string          `json:"_id"`
-	Source     json.RawMessage `json:"_source"`
-	Highlights json.RawMessage `json:"highlight"`
-	Sort       []interface{}   `json:"sort"`
+	ID          string              `json:"_id"`
+	Source      json.RawMessage     `json:"_source"`
+	Highlights  map[string][]string `json:"highlight,omitempty"`
+	Sort        []json.RawMessage   `json:"sort"`
+	SeqNo       *int                `json:"_seq_no,omitempty"`
+	PrimaryTerm *int                `json:"_primary_term,omitempty"`
 }
 
 // Elasticsearch /_search query
 
 type EsSearch struct {
-	Query    *filtering.Query       `json:"query,omitempty"`
-	Sort     map[string]EsSortOrder `json:"sort,omitempty"`
-	Collapse *EsSearchCollapse      `json:"collapse,omitempty"`
-	Pit      *EsSearchPit           `json:"pit,omitempty"`
-	Routing  string                 `json:"-"`
+	Query        *filtering.Query          `json:"query,omitempty"`
+	Sort         []EsSortField             `json:"sort,omitempty"`
+	SearchAfter  []json.RawMessage         `json:"search_after,omitempty"`
+	Collapse     *EsSearchCollapse         `json:"collapse,omitempty"`
+	Pit          *EsSearchPit              `json:"pit,omitempty"`
+	Aggregations map[string]*EsAggregation `json:"aggs,omitempty"`
+	Highlight    *EsHighlight              `json:"highlight,omitempty"`
+	// SeqNoPrimaryTerm includes the sequence number and primary term of each hit, for use with optimistic concurrency control
+	SeqNoPrimaryTerm bool `json:"seq_no_primary_term,omitempty"`
+	// Size overrides the number of hits returned by a search without pagination.
+	// Set this to zero when only aggregation results are needed.
+	Size    *int   `json:"size,omitempty"`
+	Routing string `json:"-"`
+}
+
+// EsHighlight requests fragments of the given fields that matched the query, which are returned in EsSearchResponseHit.Highlights
+type EsHighlight struct {
+	Fields map[string]struct{} `json:"fields"`
+}
+
+// EsSortField sorts by a single field. Searches take a list of them, as the order of the sort fields matters.
+type EsSortField struct {
+	Field string
+	Order EsSortOrder
+}
+
+// MarshalJSON serializes the sort field in the Elasticsearch format, {"field": "order"}
+func (s EsSortField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]EsSortOrder{
+		s.Field: s.Order,
+	})
+}
+
+func (s *EsSortField) UnmarshalJSON(data []byte) error {
+	var sort map[string]EsSortOrder
+	if err := json.Unmarshal(data, &sort); err != nil {
+		return err
+	}
+
+	if len(sort) != 1 {
+		return fmt.Errorf("expected a single sort field, got %d", len(sort))
+	}
+
+	for field, order := range sort {
+		s.Field = field
+		s.Order = order
+	}
+
+	return nil
 }
 
 type EsSortOrder string
@@ -73,6 +121,219 @@ type EsSearchPit struct {
 	KeepAlive string `json:"keep_alive"`
 }
 
+// Elasticsearch /_search aggregations
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.12/search-aggregations.html
+
+// EsAggregation describes a single aggregation. Only one aggregation type should be set,
+// along with any number of named sub-aggregations.
+type EsAggregation struct {
+	Terms         *EsTermsAggregation         `json:"terms,omitempty"`
+	Composite     *EsCompositeAggregation     `json:"composite,omitempty"`
+	DateHistogram *EsDateHistogramAggregation `json:"date_histogram,omitempty"`
+	Cardinality   *EsCardinalityAggregation   `json:"cardinality,omitempty"`
+	Filter        *filtering.Query            `json:"filter,omitempty"`
+	Filters       *EsFiltersAggregation       `json:"filters,omitempty"`
+	Nested        *EsNestedAggregation        `json:"nested,omitempty"`
+	TopHits       *EsTopHitsAggregation       `json:"top_hits,omitempty"`
+	Aggregations  map[string]*EsAggregation   `json:"aggs,omitempty"`
+}
+
+type EsTermsAggregation struct {
+	Field       string                 `json:"field"`
+	Size        int                    `json:"size,omitempty"`
+	MinDocCount *int                   `json:"min_doc_count,omitempty"`
+	Missing     interface{}            `json:"missing,omitempty"`
+	Order       map[string]EsSortOrder `json:"order,omitempty"`
+}
+
+// EsCompositeAggregation pages through the buckets for every combination of its sources' values.
+// Each source is a map with a single named values source, such as a terms aggregation. The next page starts after
+// the AfterKey of the previous result.
+type EsCompositeAggregation struct {
+	Size    int                         `json:"size,omitempty"`
+	Sources []map[string]*EsAggregation `json:"sources"`
+	After   map[string]interface{}      `json:"after,omitempty"`
+}
+
+type EsDateHistogramAggregation struct {
+	Field            string `json:"field"`
+	CalendarInterval string `json:"calendar_interval,omitempty"`
+	FixedInterval    string `json:"fixed_interval,omitempty"`
+	Format           string `json:"format,omitempty"`
+	TimeZone         string `json:"time_zone,omitempty"`
+	MinDocCount      *int   `json:"min_doc_count,omitempty"`
+}
+
+type EsCardinalityAggregation struct {
+	Field              string `json:"field"`
+	PrecisionThreshold int    `json:"precision_threshold,omitempty"`
+}
+
+// EsFiltersAggregation creates a bucket for each named filter, see EsAggregationResult.KeyedBuckets
+type EsFiltersAggregation struct {
+	Filters        map[string]*filtering.Query `json:"filters"`
+	OtherBucketKey string                      `json:"other_bucket_key,omitempty"`
+}
+
+type EsNestedAggregation struct {
+	Path string `json:"path"`
+}
+
+type EsTopHitsAggregation struct {
+	Size   int                    `json:"size,omitempty"`
+	Sort   map[string]EsSortOrder `json:"sort,omitempty"`
+	Source []string               `json:"_source,omitempty"`
+}
+
+// EsAggregationResult holds the result of a single aggregation.
+// Which fields are populated depends on the type of aggregation:
+//   - multi-bucket aggregations (terms, date_histogram) populate Buckets
+//   - the composite aggregation populates Buckets, with a key for each source, and AfterKey until there are no more pages
+//   - the filters aggregation populates KeyedBuckets, using the name of each filter as the key
+//   - single-bucket aggregations (filter, nested) populate DocCount
+//   - metric aggregations (cardinality) populate Value
+//   - the top_hits aggregation populates Hits
+//
+// Elasticsearch returns sub-aggregations as fields alongside the aggregation's own fields, keyed by the sub-aggregation name;
+// these are collected into Aggregations.
+type EsAggregationResult struct {
+	DocCount     int                             `json:"doc_count,omitempty"`
+	Value        *float64                        `json:"value,omitempty"`
+	Hits         *EsSearchResponseHits           `json:"hits,omitempty"`
+	Buckets      []*EsAggregationBucket          `json:"buckets,omitempty"`
+	AfterKey     map[string]interface{}          `json:"after_key,omitempty"`
+	KeyedBuckets map[string]*EsAggregationBucket `json:"-"`
+	Aggregations map[string]*EsAggregationResult `json:"-"`
+}
+
+type EsAggregationBucket struct {
+	// Key is the bucket's term, or the epoch milliseconds for date_histogram buckets. It's a map of source names to
+	// values for composite buckets, and empty for keyed buckets.
+	Key          interface{}                     `json:"key,omitempty"`
+	KeyAsString  string                          `json:"key_as_string,omitempty"`
+	DocCount     int                             `json:"doc_count"`
+	Aggregations map[string]*EsAggregationResult `json:"-"`
+}
+
+func (r *EsAggregationResult) UnmarshalJSON(data []byte) error {
+	type plain EsAggregationResult
+	result := struct {
+		*plain
+		Buckets json.RawMessage `json:"buckets,omitempty"`
+	}{
+		plain: (*plain)(r),
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	// buckets are returned as an array, unless the aggregation is keyed
+	if len(result.Buckets) > 0 {
+		var err error
+		if result.Buckets[0] == '{' {
+			err = json.Unmarshal(result.Buckets, &r.KeyedBuckets)
+		} else {
+			err = json.Unmarshal(result.Buckets, &r.Buckets)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	aggregations, err := unmarshalSubAggregations(data, "buckets", "hits", "after_key")
+	if err != nil {
+		return err
+	}
+	r.Aggregations = aggregations
+
+	return nil
+}
+
+func (r *EsAggregationResult) MarshalJSON() ([]byte, error) {
+	type plain EsAggregationResult
+	fields := map[string]interface{}{}
+	for name, aggregation := range r.Aggregations {
+		fields[name] = aggregation
+	}
+	if r.KeyedBuckets != nil {
+		fields["buckets"] = r.KeyedBuckets
+	}
+
+	return marshalWithFields((*plain)(r), fields)
+}
+
+func (b *EsAggregationBucket) UnmarshalJSON(data []byte) error {
+	type plain EsAggregationBucket
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+
+	aggregations, err := unmarshalSubAggregations(data, "key")
+	if err != nil {
+		return err
+	}
+	b.Aggregations = aggregations
+
+	return nil
+}
+
+func (b *EsAggregationBucket) MarshalJSON() ([]byte, error) {
+	type plain EsAggregationBucket
+	fields := map[string]interface{}{}
+	for name, aggregation := range b.Aggregations {
+		fields[name] = aggregation
+	}
+
+	return marshalWithFields((*plain)(b), fields)
+}
+
+// unmarshalSubAggregations treats every JSON object in data as a sub-aggregation, except for the fields in knownFields
+func unmarshalSubAggregations(data []byte, knownFields ...string) (map[string]*EsAggregationResult, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for _, field := range knownFields {
+		delete(fields, field)
+	}
+
+	var aggregations map[string]*EsAggregationResult
+	for name, value := range fields {
+		if len(value) == 0 || value[0] != '{' {
+			continue
+		}
+
+		aggregation := &EsAggregationResult{}
+		if err := json.Unmarshal(value, aggregation); err != nil {
+			return nil, err
+		}
+
+		if aggregations == nil {
+			aggregations = make(map[string]*EsAggregationResult)
+		}
+		aggregations[name] = aggregation
+	}
+
+	return aggregations, nil
+}
+
+// marshalWithFields marshals v to JSON, then merges in any additional fields
+func marshalWithFields(v interface{}, fields map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(fields) == 0 {
+		return data, err
+	}
+
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonpatch.MergePatch(data, patch)
+}
+
 // Elasticsearch /_doc response
 
 type EsIndexDocResponse struct {
@@ -88,6 +349,21 @@ type EsIndexDocError struct {
 	Reason string `json:"reason"`
 }
 
+// Elasticsearch error response
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/common-options.html#common-options-error-options
+
+type EsErrorResponse struct {
+	Error  *EsErrorCause `json:"error"`
+	Status int           `json:"status"`
+}
+
+type EsErrorCause struct {
+	Type      string          `json:"type"`
+	Reason    string          `json:"reason"`
+	RootCause []*EsErrorCause `json:"root_cause,omitempty"`
+	CausedBy  *EsErrorCause   `json:"caused_by,omitempty"`
+}
+
 // Elasticsearch /_delete_by_query response
 
 type EsDeleteResponse struct {
@@ -161,6 +437,39 @@ type ESPitResponse struct {
 	Id string `json:"id"`
 }
 
+type EsClosePitRequest struct {
+	Id string `json:"id"`
+}
+
+// Elasticsearch / and /_cluster/health responses
+
+type EsInfoResponse struct {
+	Version struct {
+		Number string `json:"number"`
+	} `json:"version"`
+}
+
+const (
+	ClusterHealthGreen  = "green"
+	ClusterHealthYellow = "yellow"
+)
+
+type EsClusterHealthResponse struct {
+	Status string `json:"status"`
+}
+
+// Elasticsearch /$INDEX response, filtered to the aliases and creation date of each index
+
+type EsIndex struct {
+	Aliases  map[string]interface{} `json:"aliases"`
+	Settings struct {
+		Index struct {
+			// CreationDate is in milliseconds since the epoch
+			CreationDate string `json:"creation_date"`
+		} `json:"index"`
+	} `json:"settings"`
+}
+
 type EsMultiGetItem struct {
 	Id      string `json:"_id"`
 	Index   string `json:"_index,omitempty"`
@@ -15,8 +15,12 @@
 package filtering
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"regexp/syntax"
+	"time"
+	"unicode"
 
 	"strings"
 
@@ -24,34 +28,92 @@ import (
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/operators"
 	"github.com/google/cel-go/common/overloads"
+	"github.com/google/cel-go/parser"
 	"github.com/hashicorp/go-multierror"
+	"github.com/rode/grafeas-elasticsearch/go/config"
 	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 //go:generate counterfeiter -generate
 
 //counterfeiter:generate . Filterer
 type Filterer interface {
-	ParseExpression(filter string) (*Query, error)
+	// ParseExpression translates the filter into an Elasticsearch query.
+	// If schema validation is enabled and there's a schema for the document kind, the filter is also validated against it.
+	ParseExpression(filter, documentKind string) (*Query, error)
 }
 
-type filterer struct{}
+type filterer struct {
+	config  *config.FilterConfig
+	schemas Schemas
+	// schema is the message that fields are resolved against while visiting a single expression
+	schema protoreflect.MessageDescriptor
+}
 
+// NewFilterer returns a Filterer that uses the default limits and doesn't validate fields
 func NewFilterer() Filterer {
-	return &filterer{}
+	return NewFiltererWithConfig(&config.FilterConfig{}, nil)
 }
 
-const nestedFilter = "nestedFilter"
+// NewFiltererWithConfig returns a Filterer that uses the configured limits. The schemas are used to find the types of fields,
+// and filters are only validated against them when filter.validateSchema is enabled.
+func NewFiltererWithConfig(c *config.FilterConfig, schemas Schemas) Filterer {
+	return &filterer{
+		config:  c,
+		schemas: schemas,
+	}
+}
+
+const (
+	nestedFilter = "nestedFilter"
+	now          = "now"
+	search       = "search"
+)
+
+// SearchFieldSuffix is the name of the text multi-field that the index mappings add to descriptive fields,
+// such as shortDescription, longDescription, and vulnerability.details.description
+const SearchFieldSuffix = ".text"
+
+// SearchFields are the fields matched by search(), in the field pattern syntax used by Elasticsearch
+var SearchFields = []string{"*Description" + SearchFieldSuffix, "*description" + SearchFieldSuffix}
+
+// dateValue is a date or an Elasticsearch date math expression, such as "now-72h", that can be compared against date fields
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.x/common-options.html#date-math
+type dateValue string
+
+// durationValue is a duration in Elasticsearch date math units, such as "72h". It can only be added to or subtracted from a dateValue
+type durationValue string
+
+var (
+	elasticsearchSpecialCharacterRegex = regexp.MustCompile(`([\-=&|!(){}\[\]^"~*?:\\/])`)
+	wildcardSpecialCharacterRegex      = regexp.MustCompile(`([*?\\])`)
+)
 
-var elasticsearchSpecialCharacterRegex = regexp.MustCompile(`([\-=&|!(){}\[\]^"~*?:\\/])`)
+// supportedMacros returns the CEL macros that can be translated into Elasticsearch queries.
+// Currently only has() is supported, as the comprehension macros (all, exists, map, filter) have no equivalent.
+func supportedMacros() []parser.Macro {
+	var macros []parser.Macro
+	for _, macro := range parser.AllMacros {
+		if macro.Function() == operators.Has {
+			macros = append(macros, macro)
+		}
+	}
+
+	return macros
+}
 
 // ParseExpression will serve as the entrypoint to the filter
 // that is eventually passed to visit which will handle the recursive logic
-func (f *filterer) ParseExpression(filter string) (*Query, error) {
+func (f *filterer) ParseExpression(filter, documentKind string) (*Query, error) {
 	env, err := cel.NewEnv(
 		cel.ClearMacros(),
-		cel.Declarations(decls.NewFunction(
-			nestedFilter, decls.NewOverload(nestedFilter, []*expr.Type{decls.Any}, decls.Any))),
+		cel.Macros(supportedMacros()...),
+		cel.Declarations(
+			decls.NewFunction(nestedFilter, decls.NewOverload(nestedFilter, []*expr.Type{decls.Any}, decls.Any)),
+			decls.NewFunction(now, decls.NewOverload(now, []*expr.Type{}, decls.Timestamp)),
+			decls.NewFunction(search, decls.NewOverload(search, []*expr.Type{decls.String}, decls.Bool)),
+		),
 	)
 
 	if err != nil {
@@ -67,9 +129,18 @@ func (f *filterer) ParseExpression(filter string) (*Query, error) {
 		return nil, resultErr
 	}
 
-	maybeQuery, err := f.visit(parsedExpr.Expr(), "")
+	if err := checkLimits(f.config, parsedExpr.Expr()); err != nil {
+		return nil, withLocation(parsedExpr, err)
+	}
+
+	v := &filterer{
+		config: f.config,
+		schema: f.schemas[documentKind],
+	}
+
+	maybeQuery, err := v.visit(parsedExpr.Expr(), "")
 	if err != nil {
-		return nil, err
+		return nil, withLocation(parsedExpr, err)
 	}
 
 	query, ok := maybeQuery.(*Query)
@@ -80,6 +151,19 @@ func (f *filterer) ParseExpression(filter string) (*Query, error) {
 	return query, nil
 }
 
+// withLocation adds the line and column of the expression that caused the error, if it's known
+func withLocation(parsedExpr *cel.Ast, err error) error {
+	var exprErr *exprError
+	if errors.As(err, &exprErr) {
+		offset := parsedExpr.SourceInfo().Positions[exprErr.id]
+		if location, ok := parsedExpr.Source().OffsetLocation(offset); ok {
+			return fmt.Errorf("%s (%d:%d)", exprErr.err, location.Line(), location.Column())
+		}
+	}
+
+	return err
+}
+
 func (f *filterer) visit(expression *expr.Expr, depth string) (interface{}, error) {
 	switch expression.ExprKind.(type) {
 	case *expr.Expr_IdentExpr:
@@ -90,6 +174,8 @@ func (f *filterer) visit(expression *expr.Expr, depth string) (interface{}, erro
 		return f.visitSelect(expression, depth)
 	case *expr.Expr_CallExpr:
 		return f.visitCall(expression, depth)
+	case *expr.Expr_ListExpr:
+		return f.visitList(expression, depth)
 	default:
 		return nil, fmt.Errorf("unrecognized expression: %v", expression)
 	}
@@ -115,6 +201,8 @@ func (f *filterer) visitConst(expression *expr.Expr, _ string) (interface{}, err
 		value = constantExpr.GetInt64Value()
 	case *expr.Constant_Uint64Value:
 		value = constantExpr.GetUint64Value()
+	case *expr.Constant_DoubleValue:
+		value = constantExpr.GetDoubleValue()
 	default:
 		return nil, fmt.Errorf("unrecognized constant kind %T", constantExpr.ConstantKind)
 	}
@@ -122,15 +210,39 @@ func (f *filterer) visitConst(expression *expr.Expr, _ string) (interface{}, err
 	return value, nil
 }
 
-func (f *filterer) visitSelect(expression *expr.Expr, depth string) (string, error) {
+func (f *filterer) visitList(expression *expr.Expr, depth string) ([]interface{}, error) {
+	var values []interface{}
+
+	for _, element := range expression.GetListExpr().Elements {
+		value, err := f.visit(element, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (f *filterer) visitSelect(expression *expr.Expr, depth string) (interface{}, error) {
 	selectExp := expression.GetSelectExpr()
 
 	value, err := f.visit(selectExp.Operand, depth)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	field := addPath(depth, fmt.Sprintf("%s.%s", value, selectExp.Field))
 
+	// has(a.b) is expanded into a test-only select expression
+	if selectExp.TestOnly {
+		if _, err := f.validateField(expression, field); err != nil {
+			return nil, err
+		}
+
+		return f.hasQuery(field), nil
+	}
+
 	return field, nil
 }
 
@@ -144,13 +256,27 @@ func (f *filterer) visitCall(expression *expr.Expr, depth string) (interface{},
 		operators.GreaterEquals,
 		operators.Less,
 		operators.LessEquals,
-		operators.NotEquals:
+		operators.NotEquals,
+		operators.In:
 		return f.visitBinaryOperator(expression, depth)
+	case operators.LogicalNot:
+		return f.visitLogicalNot(expression, depth)
 	case overloads.Contains,
-		overloads.StartsWith:
+		overloads.StartsWith,
+		overloads.EndsWith,
+		overloads.Matches:
 		return f.visitCallFunction(expression, depth)
 	case nestedFilter:
 		return f.visitNestedFilterCall(expression, depth)
+	case search:
+		return f.visitSearchFunction(expression, depth)
+	case overloads.TypeConvertTimestamp,
+		overloads.TypeConvertDuration,
+		now:
+		return f.visitDateFunction(expression, depth)
+	case operators.Add,
+		operators.Subtract:
+		return f.visitDateArithmetic(expression, depth)
 	default:
 		return nil, fmt.Errorf("unrecognized function: %s", function)
 	}
@@ -176,6 +302,12 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 		return nil, err
 	}
 
+	if function := expression.GetCallExpr().Function; function != operators.LogicalAnd && function != operators.LogicalOr {
+		if err := f.validateComparison(function, leftExpr, lhs, rightExpr, rhs); err != nil {
+			return nil, err
+		}
+	}
+
 	switch expression.GetCallExpr().Function {
 	case operators.LogicalAnd:
 		return &Query{
@@ -201,7 +333,7 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -217,7 +349,7 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -239,7 +371,7 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -256,7 +388,7 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -273,7 +405,7 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -291,7 +423,7 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -303,11 +435,53 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 				},
 			},
 		}, nil
+	case operators.In:
+		leftTerm, err := assertString(lhs)
+		if err != nil {
+			return nil, err
+		}
+
+		rightTerms, err := assertValueList(rhs)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Query{
+			Terms: &Terms{
+				leftTerm: rightTerms,
+			},
+		}, nil
 	}
 
 	return nil, fmt.Errorf("unrecognized function %s", expression.GetCallExpr().Function)
 }
 
+func (f *filterer) visitLogicalNot(expression *expr.Expr, depth string) (interface{}, error) {
+	args := expression.GetCallExpr().Args
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("unexpected number of arguments to logical not")
+	}
+
+	maybeQuery, err := f.visit(args[0], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	query, ok := maybeQuery.(*Query)
+	if !ok {
+		return nil, fmt.Errorf("expected %[1]v to be a valid query but was %[1]T", maybeQuery)
+	}
+
+	return &Query{
+		Bool: &Bool{
+			MustNot: &MustNot{
+				query,
+			},
+		},
+	}, nil
+}
+
 func (f *filterer) visitCallFunction(expression *expr.Expr, depth string) (interface{}, error) {
 	callExpr := expression.GetCallExpr()
 	targetExpr := callExpr.Target
@@ -337,6 +511,18 @@ func (f *filterer) visitCallFunction(expression *expr.Expr, depth string) (inter
 		return nil, err
 	}
 
+	field, err := f.validateField(targetExpr, target)
+	if err != nil {
+		return nil, err
+	}
+	if field != nil {
+		switch field.Kind() {
+		case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.EnumKind:
+		default:
+			return nil, newExprError(targetExpr, "%s can only be used on string fields, but %s has type %s", callExpr.Function, target, fieldTypeName(field))
+		}
+	}
+
 	switch callExpr.Function {
 	case overloads.StartsWith:
 		return &Query{
@@ -344,6 +530,12 @@ func (f *filterer) visitCallFunction(expression *expr.Expr, depth string) (inter
 				target: arg,
 			},
 		}, nil
+	case overloads.EndsWith:
+		return &Query{
+			Wildcard: &Term{
+				target: fmt.Sprintf("*%s", wildcardSpecialCharacterRegex.ReplaceAllString(arg, `\$1`)),
+			},
+		}, nil
 	case overloads.Contains:
 		return &Query{
 			QueryString: &QueryString{
@@ -351,6 +543,21 @@ func (f *filterer) visitCallFunction(expression *expr.Expr, depth string) (inter
 				Query:        fmt.Sprintf("*%s*", elasticsearchSpecialCharacterRegex.ReplaceAllString(arg, `\$1`)),
 			},
 		}, nil
+	case overloads.Matches:
+		pattern, err := f.toLuceneRegex(arg)
+		if err != nil {
+			return nil, &exprError{id: argExpr.Id, err: err}
+		}
+
+		return &Query{
+			Regexp: &Regexp{
+				target: {
+					Value:                 pattern,
+					Flags:                 "NONE",
+					MaxDeterminizedStates: f.config.DeterminizedStates(),
+				},
+			},
+		}, nil
 	}
 
 	return nil, fmt.Errorf("unrecognized function: %s", callExpr.Function)
@@ -375,6 +582,14 @@ func (f *filterer) visitNestedFilterCall(expression *expr.Expr, depth string) (i
 		return nil, err
 	}
 
+	field, err := f.validateField(targetExpr, target)
+	if err != nil {
+		return nil, err
+	}
+	if field != nil && field.Kind() != protoreflect.MessageKind {
+		return nil, newExprError(targetExpr, "%s can only be used on message fields, but %s has type %s", nestedFilter, target, fieldTypeName(field))
+	}
+
 	newDepth := target
 	if depth != "" {
 		newDepth = fmt.Sprintf("%s.%s", depth, newDepth)
@@ -397,6 +612,471 @@ func (f *filterer) visitNestedFilterCall(expression *expr.Expr, depth string) (i
 	}, nil
 }
 
+func (f *filterer) visitSearchFunction(expression *expr.Expr, depth string) (interface{}, error) {
+	callExpr := expression.GetCallExpr()
+
+	if callExpr.Target != nil || len(callExpr.Args) != 1 {
+		return nil, fmt.Errorf("invalid number of arguments to %s", search)
+	}
+
+	// descriptive fields are only searchable in the top-level document
+	if depth != "" {
+		return nil, newExprError(expression, "%s can't be used inside %s", search, nestedFilter)
+	}
+
+	parsedArg, err := f.visit(callExpr.Args[0], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := assertString(parsedArg)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return nil, newExprError(expression, "%s requires at least one search term", search)
+	}
+
+	return &Query{
+		SimpleQueryString: &SimpleQueryString{
+			Query:  text,
+			Fields: SearchFields,
+		},
+	}, nil
+}
+
+func (f *filterer) visitDateFunction(expression *expr.Expr, depth string) (interface{}, error) {
+	callExpr := expression.GetCallExpr()
+
+	if callExpr.Function == now {
+		if len(callExpr.Args) != 0 {
+			return nil, fmt.Errorf("invalid number of arguments to %s", now)
+		}
+
+		return dateValue(now), nil
+	}
+
+	if len(callExpr.Args) != 1 {
+		return nil, fmt.Errorf("invalid number of arguments to %s", callExpr.Function)
+	}
+
+	parsedArg, err := f.visit(callExpr.Args[0], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	arg, err := assertString(parsedArg)
+	if err != nil {
+		return nil, err
+	}
+
+	if callExpr.Function == overloads.TypeConvertTimestamp {
+		if _, err := time.Parse(time.RFC3339Nano, arg); err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q, expected RFC3339 format: %s", arg, err)
+		}
+
+		return dateValue(arg), nil
+	}
+
+	duration, err := time.ParseDuration(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %q: %s", arg, err)
+	}
+
+	return toDateMathDuration(duration)
+}
+
+func (f *filterer) visitDateArithmetic(expression *expr.Expr, depth string) (interface{}, error) {
+	callExpr := expression.GetCallExpr()
+
+	if len(callExpr.Args) != 2 {
+		return nil, fmt.Errorf("unexpected number of arguments to arithmetic operator")
+	}
+
+	lhs, err := f.visit(callExpr.Args[0], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	rhs, err := f.visit(callExpr.Args[1], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	// addition is commutative, so duration("1h") + now() is also allowed
+	if _, ok := lhs.(durationValue); ok && callExpr.Function == operators.Add {
+		lhs, rhs = rhs, lhs
+	}
+
+	date, ok := lhs.(dateValue)
+	if !ok {
+		return nil, fmt.Errorf("expected %[1]v to be a timestamp but was %[1]T", lhs)
+	}
+
+	duration, ok := rhs.(durationValue)
+	if !ok {
+		return nil, fmt.Errorf("expected %[1]v to be a duration but was %[1]T", rhs)
+	}
+
+	sign := "+"
+	if callExpr.Function == operators.Subtract {
+		sign = "-"
+	}
+
+	// date math on a fixed date needs to be separated from the anchor date with ||
+	if strings.HasPrefix(string(date), now) || strings.Contains(string(date), "||") {
+		return dateValue(fmt.Sprintf("%s%s%s", date, sign, duration)), nil
+	}
+
+	return dateValue(fmt.Sprintf("%s||%s%s", date, sign, duration)), nil
+}
+
+// toDateMathDuration converts the duration into the largest whole date math unit.
+// Elasticsearch date math doesn't support fractional seconds, so those are rejected.
+func toDateMathDuration(duration time.Duration) (durationValue, error) {
+	if duration < 0 {
+		return "", fmt.Errorf("duration %s must not be negative", duration)
+	}
+
+	if duration%time.Second != 0 {
+		return "", fmt.Errorf("duration %s must be a whole number of seconds", duration)
+	}
+
+	units := []struct {
+		unit     string
+		duration time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+	}
+
+	for _, u := range units {
+		if duration%u.duration == 0 {
+			return durationValue(fmt.Sprintf("%d%s", duration/u.duration, u.unit)), nil
+		}
+	}
+
+	return durationValue(fmt.Sprintf("%ds", duration/time.Second)), nil
+}
+
+// hasQuery matches documents where the field is set.
+// Documents are stored with every field, so scalars without presence also have to differ from their zero value, as they would for has() on a proto3 message.
+func (f *filterer) hasQuery(path string) *Query {
+	exists := &Query{
+		Exists: &Exists{
+			Field: path,
+		},
+	}
+
+	if f.schema == nil {
+		return exists
+	}
+
+	field, err := resolveField(f.schema, path)
+	if err != nil || field == nil {
+		return exists
+	}
+
+	zero, ok := zeroValue(field)
+	if !ok {
+		return exists
+	}
+
+	return &Query{
+		Bool: &Bool{
+			Must: &Must{exists},
+			MustNot: &MustNot{
+				&Query{
+					Term: &Term{
+						path: zero,
+					},
+				},
+			},
+		},
+	}
+}
+
+// validateField checks that the field exists in the schema, if validation is enabled and there is one
+func (f *filterer) validateField(expression *expr.Expr, path string) (protoreflect.FieldDescriptor, error) {
+	if f.schema == nil || !f.config.ValidateSchema {
+		return nil, nil
+	}
+
+	field, err := resolveField(f.schema, path)
+	if err != nil {
+		return nil, &exprError{id: expression.Id, err: err}
+	}
+
+	return field, nil
+}
+
+// validateComparison checks that the field exists in the schema, and that the value has a compatible type.
+// Values that aren't fields or scalars are left for the operator to reject.
+func (f *filterer) validateComparison(function string, fieldExpr *expr.Expr, maybeField interface{}, valueExpr *expr.Expr, value interface{}) error {
+	path, ok := maybeField.(string)
+	if !ok {
+		return nil
+	}
+
+	field, err := f.validateField(fieldExpr, path)
+	if err != nil || field == nil {
+		return err
+	}
+
+	if err := checkOperator(field, function); err != nil {
+		return &exprError{id: fieldExpr.Id, err: err}
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	for _, v := range values {
+		scalarValue, err := assertValue(v)
+		if err != nil {
+			continue
+		}
+
+		if err := checkValue(field, scalarValue); err != nil {
+			return &exprError{id: valueExpr.Id, err: err}
+		}
+	}
+
+	return nil
+}
+
+// toLuceneRegex validates a CEL (RE2) regular expression and converts it into the Lucene syntax used by Elasticsearch.
+// RE2 matches anywhere in the string unless the pattern is anchored, while Lucene patterns always match the entire term,
+// so unanchored patterns are padded with .* instead.
+// Patterns that Lucene can't express, or that are likely to be expensive to evaluate, are rejected.
+func (f *filterer) toLuceneRegex(pattern string) (string, error) {
+	if maxLength := f.config.RegexLength(); len(pattern) > maxLength {
+		return "", fmt.Errorf("regular expression is longer than the maximum of %d characters", maxLength)
+	}
+
+	anchoredStart := strings.HasPrefix(pattern, "^")
+	anchoredEnd := strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`)
+	unanchored := strings.TrimPrefix(pattern, "^")
+	if anchoredEnd {
+		unanchored = strings.TrimSuffix(unanchored, "$")
+	}
+
+	re, err := syntax.Parse(unanchored, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid regular expression %q: %s", pattern, err)
+	}
+
+	// the anchors in ^a|b$ only apply to one side of the alternation, which can't be expressed once they're removed
+	if original, err := syntax.Parse(pattern, syntax.Perl); err == nil && (anchoredStart || anchoredEnd) && original.Op == syntax.OpAlternate {
+		return "", fmt.Errorf("unsupported regular expression %q: anchors must apply to the whole pattern, e.g. ^(a|b)$", pattern)
+	}
+
+	if err := validateRegex(re, false); err != nil {
+		return "", fmt.Errorf("unsupported regular expression %q: %s", pattern, err)
+	}
+
+	var builder strings.Builder
+	if !anchoredStart {
+		builder.WriteString(".*")
+	}
+	builder.WriteString("(")
+	if err := writeLuceneRegex(&builder, re); err != nil {
+		return "", fmt.Errorf("unsupported regular expression %q: %s", pattern, err)
+	}
+	builder.WriteString(")")
+	if !anchoredEnd {
+		builder.WriteString(".*")
+	}
+
+	return builder.String(), nil
+}
+
+const (
+	// luceneReservedCharacters are escaped in literals. This includes the optional operators, which are disabled by the NONE flag.
+	luceneReservedCharacters = `.?+*|{}[]()"\#@&~<>^$`
+	// luceneClassReservedCharacters are escaped inside character classes
+	luceneClassReservedCharacters = luceneReservedCharacters + "-"
+	// maxCharClassRanges keeps very large classes, like Unicode categories, from turning into huge Lucene patterns
+	maxCharClassRanges = 64
+)
+
+// writeLuceneRegex writes a parsed RE2 expression in Lucene syntax. Lucene doesn't have Perl (\d) or POSIX ([[:alpha:]])
+// classes, or escapes like \x41, so literals and character classes are written out from the runes they match.
+func writeLuceneRegex(builder *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		builder.WriteString("()")
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			writeLuceneRune(builder, r, luceneReservedCharacters)
+		}
+	case syntax.OpCharClass:
+		return writeLuceneCharClass(builder, re.Rune)
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		// Lucene's . also matches newlines, which indexed keywords are very unlikely to contain
+		builder.WriteString(".")
+	case syntax.OpCapture:
+		builder.WriteString("(")
+		if err := writeLuceneRegex(builder, re.Sub[0]); err != nil {
+			return err
+		}
+		builder.WriteString(")")
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		sub := re.Sub[0]
+		grouped := (sub.Op == syntax.OpLiteral && len(sub.Rune) > 1) || sub.Op == syntax.OpConcat || sub.Op == syntax.OpAlternate
+		if grouped {
+			builder.WriteString("(")
+		}
+		if err := writeLuceneRegex(builder, sub); err != nil {
+			return err
+		}
+		if grouped {
+			builder.WriteString(")")
+		}
+
+		switch {
+		case re.Op == syntax.OpStar:
+			builder.WriteString("*")
+		case re.Op == syntax.OpPlus:
+			builder.WriteString("+")
+		case re.Op == syntax.OpQuest:
+			builder.WriteString("?")
+		case re.Max == -1:
+			builder.WriteString(fmt.Sprintf("{%d,}", re.Min))
+		case re.Min == re.Max:
+			builder.WriteString(fmt.Sprintf("{%d}", re.Min))
+		default:
+			builder.WriteString(fmt.Sprintf("{%d,%d}", re.Min, re.Max))
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			grouped := sub.Op == syntax.OpAlternate
+			if grouped {
+				builder.WriteString("(")
+			}
+			if err := writeLuceneRegex(builder, sub); err != nil {
+				return err
+			}
+			if grouped {
+				builder.WriteString(")")
+			}
+		}
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			if i > 0 {
+				builder.WriteString("|")
+			}
+			if err := writeLuceneRegex(builder, sub); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s is not supported", re)
+	}
+
+	return nil
+}
+
+// writeLuceneCharClass writes the rune ranges of a character class. RE2 expands negated classes, like [^a] and \D,
+// into every other range, so those are negated again to keep the Lucene pattern short.
+func writeLuceneCharClass(builder *strings.Builder, ranges []rune) error {
+	negated := len(ranges) > 0 && ranges[0] == 0 && ranges[len(ranges)-1] == unicode.MaxRune
+	if negated {
+		ranges = complementRanges(ranges)
+		if len(ranges) == 0 {
+			builder.WriteString(".")
+			return nil
+		}
+	}
+
+	if len(ranges) == 0 {
+		return fmt.Errorf("empty character classes are not supported")
+	}
+
+	if len(ranges)/2 > maxCharClassRanges {
+		return fmt.Errorf("character classes with more than %d ranges, such as Unicode classes like \\pL, are not supported", maxCharClassRanges)
+	}
+
+	builder.WriteString("[")
+	if negated {
+		builder.WriteString("^")
+	}
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		writeLuceneRune(builder, lo, luceneClassReservedCharacters)
+		if hi > lo+1 {
+			builder.WriteString("-")
+		}
+		if hi > lo {
+			writeLuceneRune(builder, hi, luceneClassReservedCharacters)
+		}
+	}
+	builder.WriteString("]")
+
+	return nil
+}
+
+// complementRanges returns the runes that aren't in the given sorted ranges
+func complementRanges(ranges []rune) []rune {
+	var complement []rune
+	next := rune(0)
+
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > next {
+			complement = append(complement, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+
+	if next <= unicode.MaxRune {
+		complement = append(complement, next, unicode.MaxRune)
+	}
+
+	return complement
+}
+
+func writeLuceneRune(builder *strings.Builder, r rune, reserved string) {
+	if strings.ContainsRune(reserved, r) {
+		builder.WriteRune('\\')
+	}
+
+	builder.WriteRune(r)
+}
+
+func validateRegex(re *syntax.Regexp, inRepeat bool) error {
+	if re.Flags&syntax.NonGreedy != 0 {
+		return fmt.Errorf("non-greedy repetition is not supported")
+	}
+
+	if re.Flags&syntax.FoldCase != 0 {
+		return fmt.Errorf("case-insensitive matching is not supported")
+	}
+
+	switch re.Op {
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return fmt.Errorf("word boundaries are not supported")
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return fmt.Errorf("anchors are only supported at the start and end of the pattern")
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		// nested repetition, like (a+)+, is the classic cause of catastrophic backtracking and state explosion
+		if inRepeat {
+			return fmt.Errorf("nested repetition is not allowed")
+		}
+		inRepeat = true
+	}
+
+	for _, sub := range re.Sub {
+		if err := validateRegex(sub, inRepeat); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func assertString(value interface{}) (string, error) {
 	stringValue, ok := value.(string)
 	if !ok {
@@ -406,6 +1086,37 @@ func assertString(value interface{}) (string, error) {
 	return stringValue, nil
 }
 
+// assertValue checks that the value is a scalar that can be compared against a field
+func assertValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool, int64, uint64, float64, string:
+		return value, nil
+	case dateValue:
+		return string(v), nil
+	}
+
+	return nil, fmt.Errorf("expected %[1]v to be a bool, number, or string but was %[1]T", value)
+}
+
+func assertValueList(value interface{}) ([]interface{}, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected %[1]v to be a list but was %[1]T", value)
+	}
+
+	scalarValues := make([]interface{}, len(values))
+	for i, v := range values {
+		scalarValue, err := assertValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		scalarValues[i] = scalarValue
+	}
+
+	return scalarValues, nil
+}
+
 func addPath(path, field string) string {
 	if path == "" || strings.HasPrefix(field, path) {
 		return field
@@ -16,6 +16,12 @@ package filtering
 
 import (
 	"encoding/json"
+	"fmt"
+
+	protov1 "github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/config"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -25,7 +31,7 @@ import (
 var _ = Describe("Filter", func() {
 	Describe("ParseExpression", func() {
 		DescribeTable("filter cases", func(filter string, expected interface{}) {
-			result, err := NewFilterer().ParseExpression(filter)
+			result, err := NewFilterer().ParseExpression(filter, "")
 			resultJson, _ := json.MarshalIndent(result, "", "  ")
 
 			Expect(err).ToNot(HaveOccurred())
@@ -363,10 +369,633 @@ var _ = Describe("Filter", func() {
 					},
 				},
 			}),
+			Entry("in list", `kind in ["VULNERABILITY", "BUILD"]`, &Query{
+				Terms: &Terms{
+					"kind": {"VULNERABILITY", "BUILD"},
+				},
+			}),
+			Entry("in list on select expression", `a.b.c in ["d"]`, &Query{
+				Terms: &Terms{
+					"a.b.c": {"d"},
+				},
+			}),
+			Entry("in empty list", `a in []`, &Query{
+				Terms: &Terms{
+					"a": {},
+				},
+			}),
+			Entry("and with in list", `a == "b" && c in ["d", "e"]`, &Query{
+				Bool: &Bool{
+					Must: &Must{
+						&Query{
+							Term: &Term{
+								"a": "b",
+							},
+						},
+						&Query{
+							Terms: &Terms{
+								"c": {"d", "e"},
+							},
+						},
+					},
+				},
+			}),
+			Entry("equals bool", `deleted == false`, &Query{
+				Term: &Term{
+					"deleted": false,
+				},
+			}),
+			Entry("equals int", `count == 3`, &Query{
+				Term: &Term{
+					"count": int64(3),
+				},
+			}),
+			Entry("equals uint", `count == 3u`, &Query{
+				Term: &Term{
+					"count": uint64(3),
+				},
+			}),
+			Entry("not equals bool", `a.b != true`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Bool{
+							Term: &Term{
+								"a.b": true,
+							},
+						},
+					},
+				},
+			}),
+			Entry("greater than double", `vulnerability.cvssScore > 7.5`, &Query{
+				Range: &Range{
+					"vulnerability.cvssScore": {
+						Greater: 7.5,
+					},
+				},
+			}),
+			Entry("greater than or equals int", `count >= 3`, &Query{
+				Range: &Range{
+					"count": {
+						GreaterEquals: int64(3),
+					},
+				},
+			}),
+			Entry("less than zero", `a < 0`, &Query{
+				Range: &Range{
+					"a": {
+						Less: int64(0),
+					},
+				},
+			}),
+			Entry("less than or equals negative double", `a <= -1.5`, &Query{
+				Range: &Range{
+					"a": {
+						LessEquals: -1.5,
+					},
+				},
+			}),
+			Entry("in list of mixed types", `a in ["b", 1, 2.5, true]`, &Query{
+				Terms: &Terms{
+					"a": {"b", int64(1), 2.5, true},
+				},
+			}),
+			Entry("greater than timestamp", `createTime > timestamp("2026-01-01T00:00:00Z")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Greater: "2026-01-01T00:00:00Z",
+					},
+				},
+			}),
+			Entry("equals timestamp", `createTime == timestamp("2026-01-01T12:30:00.5+02:00")`, &Query{
+				Term: &Term{
+					"createTime": "2026-01-01T12:30:00.5+02:00",
+				},
+			}),
+			Entry("less than now", `updateTime < now()`, &Query{
+				Range: &Range{
+					"updateTime": {
+						Less: "now",
+					},
+				},
+			}),
+			Entry("now minus duration", `createTime >= now() - duration("72h")`, &Query{
+				Range: &Range{
+					"createTime": {
+						GreaterEquals: "now-3d",
+					},
+				},
+			}),
+			Entry("now plus duration", `createTime <= now() + duration("90m")`, &Query{
+				Range: &Range{
+					"createTime": {
+						LessEquals: "now+90m",
+					},
+				},
+			}),
+			Entry("duration plus now", `createTime <= duration("1h") + now()`, &Query{
+				Range: &Range{
+					"createTime": {
+						LessEquals: "now+1h",
+					},
+				},
+			}),
+			Entry("timestamp minus duration", `createTime > timestamp("2026-01-01T00:00:00Z") - duration("1h30m")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Greater: "2026-01-01T00:00:00Z||-90m",
+					},
+				},
+			}),
+			Entry("chained date math", `createTime > now() - duration("24h") + duration("45s")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Greater: "now-1d+45s",
+					},
+				},
+			}),
+			Entry("chained date math on timestamp", `createTime > timestamp("2026-01-01T00:00:00Z") - duration("24h") + duration("1m")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Greater: "2026-01-01T00:00:00Z||-1d+1m",
+					},
+				},
+			}),
+			Entry("endsWith", `"resource.uri".endsWith(":latest")`, &Query{
+				Wildcard: &Term{
+					"resource.uri": "*:latest",
+				},
+			}),
+			Entry("endsWith with escaped wildcard characters", `a.endsWith("b*c?d\\e")`, &Query{
+				Wildcard: &Term{
+					"a": `*b\*c\?d\\e`,
+				},
+			}),
+			Entry("matches", `"resource.uri".matches("gcr.io/.+:v[0-9]+")`, &Query{
+				Regexp: &Regexp{
+					"resource.uri": {
+						Value:                 ".*(gcr.io/.+:v[0-9]+).*",
+						Flags:                 "NONE",
+						MaxDeterminizedStates: config.DefaultMaxDeterminizedStates,
+					},
+				},
+			}),
+			Entry("matches anchored at the start", `a.matches("^gcr\\.io/")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value:                 `(gcr\.io/).*`,
+						Flags:                 "NONE",
+						MaxDeterminizedStates: config.DefaultMaxDeterminizedStates,
+					},
+				},
+			}),
+			Entry("matches anchored at both ends", `a.matches("^(bc|de)$")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value:                 "((bc|de))",
+						Flags:                 "NONE",
+						MaxDeterminizedStates: config.DefaultMaxDeterminizedStates,
+					},
+				},
+			}),
+			Entry("matches with a literal dollar sign at the end", `a.matches("b\\$")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value:                 `.*(b\$).*`,
+						Flags:                 "NONE",
+						MaxDeterminizedStates: config.DefaultMaxDeterminizedStates,
+					},
+				},
+			}),
+			Entry("matches unanchored alternation", `a.matches("bc|de")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value:                 ".*(bc|de).*",
+						Flags:                 "NONE",
+						MaxDeterminizedStates: config.DefaultMaxDeterminizedStates,
+					},
+				},
+			}),
+			Entry("matches with quotes", `a.matches('"b\\"c"')`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value:                 `.*(\"b\"c\").*`,
+						Flags:                 "NONE",
+						MaxDeterminizedStates: config.DefaultMaxDeterminizedStates,
+					},
+				},
+			}),
+			Entry("logical not", `!(a == "b")`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Term: &Term{
+								"a": "b",
+							},
+						},
+					},
+				},
+			}),
+			Entry("logical not with startsWith", `!a.startsWith("b")`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Prefix: &Term{
+								"a": "b",
+							},
+						},
+					},
+				},
+			}),
+			Entry("logical not of or set", `!(a == "b" || c == "d")`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Bool: &Bool{
+								Should: &Should{
+									&Query{
+										Term: &Term{
+											"a": "b",
+										},
+									},
+									&Query{
+										Term: &Term{
+											"c": "d",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("has", `has(a.b)`, &Query{
+				Exists: &Exists{
+					Field: "a.b",
+				},
+			}),
+			Entry("has on deeply selected field", `has(vulnerability.packageIssue.fixedLocation)`, &Query{
+				Exists: &Exists{
+					Field: "vulnerability.packageIssue.fixedLocation",
+				},
+			}),
+			Entry("not has", `!has(attestation.attestation)`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Exists: &Exists{
+								Field: "attestation.attestation",
+							},
+						},
+					},
+				},
+			}),
+			Entry("and with not has", `kind == "ATTESTATION" && !has(attestation.attestation)`, &Query{
+				Bool: &Bool{
+					Must: &Must{
+						&Query{
+							Term: &Term{
+								"kind": "ATTESTATION",
+							},
+						},
+						&Query{
+							Bool: &Bool{
+								MustNot: &MustNot{
+									&Query{
+										Exists: &Exists{
+											Field: "attestation.attestation",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("nestedFilter with has", `a.nestedFilter(has(b.c))`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Exists: &Exists{
+							Field: "a.b.c",
+						},
+					},
+				},
+			}),
+			Entry("nestedFilter with in list", `a.nestedFilter(b in ["c", "d"])`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Terms: &Terms{
+							"a.b": {"c", "d"},
+						},
+					},
+				},
+			}),
+			Entry("search", `search("log4j remote code")`, &Query{
+				SimpleQueryString: &SimpleQueryString{
+					Query:  "log4j remote code",
+					Fields: []string{"*Description.text", "*description.text"},
+				},
+			}),
+			Entry("search with another filter", `kind == "VULNERABILITY" && search("\"remote code\" -windows")`, &Query{
+				Bool: &Bool{
+					Must: &Must{
+						&Query{
+							Term: &Term{
+								"kind": "VULNERABILITY",
+							},
+						},
+						&Query{
+							SimpleQueryString: &SimpleQueryString{
+								Query:  `"remote code" -windows`,
+								Fields: []string{"*Description.text", "*description.text"},
+							},
+						},
+					},
+				},
+			}),
+		)
+
+		DescribeTable("regular expressions", func(pattern, expected string) {
+			result, err := NewFilterer().ParseExpression(fmt.Sprintf("a.matches(%q)", pattern), "")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect((*result.Regexp)["a"].Value).To(Equal(expected))
+		},
+			Entry("perl digit class", `^v\d+$`, "(v[0-9]+)"),
+			Entry("negated perl digit class", `^\D$`, "([^0-9])"),
+			Entry("perl whitespace class", `^a\sb$`, "(a[\t\n\f\r ]b)"),
+			Entry("perl word class", `^\w$`, "([0-9A-Z_a-z])"),
+			Entry("posix class", `^[[:alpha:]]$`, "([A-Za-z])"),
+			Entry("hex escape", `^\x41$`, "(A)"),
+			Entry("negated class", `^[^a-c]$`, "([^a-c])"),
+			Entry("class with reserved characters", `^[-\]"]$`, `([\"\-\]])`),
+			Entry("any character, including newlines", `^[\s\S]$`, "(.)"),
+			Entry("reserved characters in literals", `^a#b@c&d~e<f>g$`, `(a\#b\@c\&d\~e\<f\>g)`),
+			Entry("repetition of a group", `^(ab)*$`, "((ab)*)"),
+			Entry("bounded repetition", `^a{2}b{1,}c{1,3}$`, "(a{2}b{1,}c{1,3})"),
+			Entry("optional", `^ab?$`, "(ab?)"),
+			Entry("alternation in a group", `^x(bc|de)y$`, "(x(bc|de)y)"),
+			Entry("escaped paren followed by an optional character", `^a\(?b$`, `(a\(?b)`),
+			Entry("non-capturing group, which is written as a group", `^(?:ab)+c$`, "((ab)+c)"),
+			Entry("flag that doesn't change a match of the whole term", `^(?s)a.b$`, "(a.b)"),
+		)
+
+		DescribeTable("unsupported regular expressions", func(pattern, expectedError string) {
+			result, err := NewFilterer().ParseExpression(fmt.Sprintf("a.matches(%q)", pattern), "")
+
+			Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			Expect(result).To(BeNil())
+		},
+			Entry("unicode class", `\pL`, "more than 64 ranges"),
+			Entry("negated unicode class", `\PN`, "more than 64 ranges"),
+			Entry("empty class", `[^\x00-\x{10FFFF}]`, "empty character classes are not supported"),
+			Entry("case-insensitive flag", `(?i)abc`, "case-insensitive matching is not supported"),
+			Entry("case-insensitive flag in a group", `a\(?(?i:b)`, "case-insensitive matching is not supported"),
+			Entry("multi-line flag", `(?m)a$b`, "anchors are only supported at the start and end of the pattern"),
+			Entry("ungreedy flag", `(?U)a+`, "non-greedy repetition is not supported"),
+			Entry("location of the error", `a\pN`, "(1:10)"),
+		)
+
+		When("filter limits are configured", func() {
+			var filterer Filterer
+
+			BeforeEach(func() {
+				filterer = NewFiltererWithConfig(&config.FilterConfig{
+					MaxRegexLength:        5,
+					MaxDeterminizedStates: 100,
+				}, nil)
+			})
+
+			It("should use the configured regexp state limit", func() {
+				result, err := filterer.ParseExpression(`a.matches("b+")`, "")
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect((*result.Regexp)["a"].MaxDeterminizedStates).To(Equal(100))
+			})
+
+			It("should reject regular expressions that are too long", func() {
+				result, err := filterer.ParseExpression(`a.matches("bcdefg")`, "")
+
+				Expect(err).To(MatchError(ContainSubstring("maximum of 5 characters")))
+				Expect(result).To(BeNil())
+			})
+		})
+
+		Context("complexity limits", func() {
+			var filterConfig *config.FilterConfig
+
+			BeforeEach(func() {
+				filterConfig = &config.FilterConfig{
+					MaxDepth:         4,
+					MaxClauses:       3,
+					MaxWildcardTerms: 2,
+				}
+			})
+
+			DescribeTable("filters within the limits", func(filter string) {
+				result, err := NewFiltererWithConfig(filterConfig, nil).ParseExpression(filter, "")
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+			},
+				Entry("nested to the maximum depth", `a.b.c == "d"`),
+				Entry("maximum number of clauses", `a == "b" && (c > 1 || has(d.e))`),
+				Entry("maximum number of in list values", `a in ["b", "c", "d"]`),
+				Entry("maximum number of wildcard terms", `a.contains("b") || a.endsWith("c")`),
+			)
+
+			DescribeTable("filters over the limits", func(filter, expectedError string) {
+				result, err := NewFiltererWithConfig(filterConfig, nil).ParseExpression(filter, "")
+
+				Expect(err).To(MatchError(expectedError))
+				Expect(result).To(BeNil())
+			},
+				Entry("too deep", `a.b.c.d == "e"`, "filter is nested deeper than the maximum depth of 4 (1:0)"),
+				Entry("too many clauses", `a == "b" && c == "d" && e.startsWith("f") && g < 1`, "filter has more than the maximum of 3 clauses (1:47)"),
+				Entry("too many in list values", `a in ["b", "c", "d", "e"]`, "filter has more than the maximum of 3 clauses (1:2)"),
+				Entry("too many wildcard terms", `a.contains("b") || a.endsWith("c") || a.matches("^d")`, "filter has more than the maximum of 2 contains, endsWith, and matches functions (1:47)"),
+			)
+
+			When("leading wildcards are disallowed", func() {
+				BeforeEach(func() {
+					filterConfig.DisallowLeadingWildcards = true
+				})
+
+				DescribeTable("filters without leading wildcards", func(filter string) {
+					result, err := NewFiltererWithConfig(filterConfig, nil).ParseExpression(filter, "")
+
+					Expect(err).ToNot(HaveOccurred())
+					Expect(result).ToNot(BeNil())
+				},
+					Entry("startsWith", `a.startsWith("b")`),
+					Entry("anchored matches", `a.matches("^b+$")`),
+				)
+
+				DescribeTable("filters with leading wildcards", func(filter, expectedError string) {
+					result, err := NewFiltererWithConfig(filterConfig, nil).ParseExpression(filter, "")
+
+					Expect(err).To(MatchError(expectedError))
+					Expect(result).To(BeNil())
+				},
+					Entry("contains", `a.contains("b")`, "contains is not allowed, because it requires a leading wildcard (1:10)"),
+					Entry("endsWith", `a.endsWith("b")`, "endsWith is not allowed, because it requires a leading wildcard (1:10)"),
+					Entry("unanchored matches", `a.matches("b$")`, "matches is not allowed, because it requires a leading wildcard (1:9)"),
+				)
+			})
+		})
+
+		Context("schema validation", func() {
+			var filterer Filterer
+
+			BeforeEach(func() {
+				filterer = NewFiltererWithConfig(&config.FilterConfig{ValidateSchema: true}, Schemas{
+					"occurrences": protov1.MessageV2(&pb.Occurrence{}).ProtoReflect().Descriptor(),
+					"notes":       protov1.MessageV2(&pb.Note{}).ProtoReflect().Descriptor(),
+					"projects":    protov1.MessageV2(&prpb.Project{}).ProtoReflect().Descriptor(),
+				})
+			})
+
+			DescribeTable("valid filters", func(documentKind, filter string) {
+				result, err := filterer.ParseExpression(filter, documentKind)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).ToNot(BeNil())
+			},
+				Entry("string field", "occurrences", `resource.uri == "gcr.io/foo"`),
+				Entry("quoted field", "occurrences", `"resource.uri".startsWith("gcr.io")`),
+				Entry("enum field", "occurrences", `kind == "VULNERABILITY"`),
+				Entry("enum field with in", "occurrences", `kind in ["VULNERABILITY", "BUILD"]`),
+				Entry("float field", "occurrences", `vulnerability.cvssScore > 7.5`),
+				Entry("float field with an int", "occurrences", `vulnerability.cvssScore >= 7`),
+				Entry("bool field", "notes", `vulnerability.details.isObsolete == false`),
+				Entry("int field", "occurrences", `vulnerability.packageIssue.fixedLocation.version.epoch > 0`),
+				Entry("timestamp field", "occurrences", `createTime > now() - duration("24h")`),
+				Entry("64-bit int field", "notes", `intoto.threshold == 2`),
+				Entry("has", "occurrences", `has(vulnerability.packageIssue) || !has(attestation.attestation)`),
+				Entry("nestedFilter", "occurrences", `vulnerability.packageIssue.nestedFilter(affectedLocation.cpeUri == "cpe")`),
+				Entry("project field", "projects", `name.startsWith("projects/")`),
+				Entry("document kind without a schema", "other", `shortDescriptionn == "foo"`),
+			)
+
+			DescribeTable("has", func(documentKind, filter string, expected *Query) {
+				result, err := filterer.ParseExpression(filter, documentKind)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).To(Equal(expected))
+			},
+				Entry("string field, which doesn't match the empty string", "occurrences", `has(resource.uri)`, &Query{
+					Bool: &Bool{
+						Must: &Must{
+							&Query{Exists: &Exists{Field: "resource.uri"}},
+						},
+						MustNot: &MustNot{
+							&Query{Term: &Term{"resource.uri": ""}},
+						},
+					},
+				}),
+				Entry("enum field, which doesn't match the unspecified value", "occurrences", `has(vulnerability.severity)`, &Query{
+					Bool: &Bool{
+						Must: &Must{
+							&Query{Exists: &Exists{Field: "vulnerability.severity"}},
+						},
+						MustNot: &MustNot{
+							&Query{Term: &Term{"vulnerability.severity": "SEVERITY_UNSPECIFIED"}},
+						},
+					},
+				}),
+				Entry("float field, which doesn't match 0", "occurrences", `has(vulnerability.cvssScore)`, &Query{
+					Bool: &Bool{
+						Must: &Must{
+							&Query{Exists: &Exists{Field: "vulnerability.cvssScore"}},
+						},
+						MustNot: &MustNot{
+							&Query{Term: &Term{"vulnerability.cvssScore": 0}},
+						},
+					},
+				}),
+				Entry("bool field, which doesn't match false", "notes", `has(vulnerability.details.isObsolete)`, &Query{
+					Bool: &Bool{
+						Must: &Must{
+							&Query{Exists: &Exists{Field: "vulnerability.details.isObsolete"}},
+						},
+						MustNot: &MustNot{
+							&Query{Term: &Term{"vulnerability.details.isObsolete": false}},
+						},
+					},
+				}),
+				Entry("64-bit int field, which doesn't match \"0\"", "notes", `has(intoto.threshold)`, &Query{
+					Bool: &Bool{
+						Must: &Must{
+							&Query{Exists: &Exists{Field: "intoto.threshold"}},
+						},
+						MustNot: &MustNot{
+							&Query{Term: &Term{"intoto.threshold": "0"}},
+						},
+					},
+				}),
+				Entry("message field", "occurrences", `has(vulnerability.packageIssue)`, &Query{
+					Exists: &Exists{Field: "vulnerability.packageIssue"},
+				}),
+				Entry("list field", "notes", `has(deployable.resourceUri)`, &Query{
+					Exists: &Exists{Field: "deployable.resourceUri"},
+				}),
+				Entry("document kind without a schema", "other", `has(a.b)`, &Query{
+					Exists: &Exists{Field: "a.b"},
+				}),
+			)
+
+			DescribeTable("invalid filters", func(documentKind, filter, expectedError string) {
+				result, err := filterer.ParseExpression(filter, documentKind)
+
+				Expect(err).To(MatchError(expectedError))
+				Expect(result).To(BeNil())
+			},
+				Entry("unknown field", "occurrences", `resource.urii == "x"`, `field "resource.urii" does not exist on grafeas.v1beta1.Resource (1:8)`),
+				Entry("proto field name instead of json name", "occurrences", `note_name == "x"`, `field "note_name" does not exist on grafeas.v1beta1.Occurrence (1:0)`),
+				Entry("location on a later line", "occurrences", "kind == \"BUILD\" &&\n  resource.foo == \"x\"", `field "resource.foo" does not exist on grafeas.v1beta1.Resource (2:10)`),
+				Entry("field of a scalar", "occurrences", `resource.uri.foo == "x"`, `field "resource.uri.foo" does not exist: "resource.uri" is not a message (1:12)`),
+				Entry("string field compared to a number", "occurrences", `resource.uri == 1`, `field uri has type string, but 1 has type int64 (1:16)`),
+				Entry("float field compared to a string", "occurrences", `vulnerability.cvssScore > "high"`, `field cvssScore has type float, but high has type string (1:26)`),
+				Entry("invalid enum value", "occurrences", `kind == "VULNERABILTY"`, `"VULNERABILTY" is not a valid value for grafeas.v1beta1.NoteKind (1:8)`),
+				Entry("invalid enum value in list", "occurrences", `kind in ["BUILD", "BILD"]`, `"BILD" is not a valid value for grafeas.v1beta1.NoteKind (1:8)`),
+				Entry("range on a 64-bit int field", "notes", `intoto.threshold >= 2`, `field threshold is a 64-bit integer, which is stored as a string, so it can't be compared with >= (1:6)`),
+				Entry("message compared to a value", "occurrences", `resource == "x"`, `field resource is a message and can't be compared to a value (1:12)`),
+				Entry("unknown field in has", "occurrences", `has(resource.urii)`, `field "resource.urii" does not exist on grafeas.v1beta1.Resource (1:3)`),
+				Entry("string function on a non-string field", "occurrences", `vulnerability.cvssScore.startsWith("7")`, `startsWith can only be used on string fields, but vulnerability.cvssScore has type float (1:13)`),
+				Entry("nestedFilter on a non-message field", "occurrences", `resource.uri.nestedFilter(a == "b")`, `nestedFilter can only be used on message fields, but resource.uri has type string (1:8)`),
+				Entry("unknown field in nestedFilter", "occurrences", `vulnerability.packageIssue.nestedFilter(cpeUri == "cpe")`, `field "vulnerability.packageIssue.cpeUri" does not exist on grafeas.v1beta1.vulnerability.PackageIssue (1:40)`),
+			)
+		})
+
+		DescribeTable("IsFullTextSearch", func(filter string, expected bool) {
+			result, err := NewFilterer().ParseExpression(filter, "")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.IsFullTextSearch()).To(Equal(expected))
+		},
+			Entry("search", `search("a")`, true),
+			Entry("search with and", `a == "b" && search("c")`, true),
+			Entry("search with or", `a == "b" || (c == "d" && search("e"))`, true),
+			Entry("negated search", `!search("a")`, false),
+			Entry("no search", `a == "b" && c.contains("d")`, false),
 		)
 
+		It("should serialize typed values as the matching JSON types", func() {
+			result, err := NewFilterer().ParseExpression(`a == true && b >= 0 && c < 7.5 && d == "e"`, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			resultJson, err := json.Marshal(result)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(string(resultJson)).To(ContainSubstring(`{"term":{"a":true}}`))
+			Expect(string(resultJson)).To(ContainSubstring(`{"range":{"b":{"gte":0}}}`))
+			Expect(string(resultJson)).To(ContainSubstring(`{"range":{"c":{"lt":7.5}}}`))
+			Expect(string(resultJson)).To(ContainSubstring(`{"term":{"d":"e"}}`))
+		})
+
 		DescribeTable("error handling", func(filter string) {
-			result, err := NewFilterer().ParseExpression(filter)
+			result, err := NewFilterer().ParseExpression(filter, "")
 
 			Expect(err).To(HaveOccurred())
 			Expect(result).To(BeNil())
@@ -383,6 +1012,36 @@ var _ = Describe("Filter", func() {
 			Entry("and comparison with lhs value containing unknown operator without quotes", `a/b&&c==d`),
 			Entry("and comparison with rhs value containing unknown operator without quotes", `a==b&&c/d`),
 			Entry("nestedFilter with no expression arg", `a.nestedFilter()`),
+			Entry("in with a non-list rhs", `a in "b"`),
+			Entry("logical not of a non-query", `!a`),
+			Entry("endsWith with a non-string argument", `a.endsWith(1)`),
+			Entry("invalid regular expression", `a.matches("b(")`),
+			Entry("regular expression with nested repetition", `a.matches("(b+)+")`),
+			Entry("regular expression with nested bounded repetition", `a.matches("(b{1,5})*")`),
+			Entry("regular expression with non-greedy repetition", `a.matches("b.*?c")`),
+			Entry("regular expression with case-insensitive flag", `a.matches("(?i)b")`),
+			Entry("regular expression with a word boundary", `a.matches("\\bb")`),
+			Entry("regular expression with an anchor in the middle", `a.matches("b^c")`),
+			Entry("regular expression with anchored alternation", `a.matches("^b|c$")`),
+			Entry("invalid timestamp", `createTime > timestamp("yesterday")`),
+			Entry("invalid duration", `createTime > now() - duration("3 days")`),
+			Entry("fractional duration", `createTime > now() - duration("1.5s")`),
+			Entry("negative duration", `createTime > now() - duration("-1h")`),
+			Entry("comparison with a duration", `createTime > duration("1h")`),
+			Entry("subtracting a timestamp", `createTime > now() - now()`),
+			Entry("arithmetic on numbers", `a > 1 + 2`),
+			Entry("now with arguments", `createTime > now("a")`),
+			Entry("timestamp with a non-string argument", `createTime > timestamp(1)`),
+			Entry("has without a selected field", `has(a)`),
+			Entry("unsupported macro", `a.all(x, x == "b")`),
+			Entry("in list containing a query", `a in ["b", c == "d"]`),
+			Entry("equals with a query on the rhs", `a == (b == "c")`),
+			Entry("range with a list on the rhs", `a > [1, 2]`),
+			Entry("in list with an invalid element", `a in [b/c]`),
+			Entry("search with a non-string argument", `search(1)`),
+			Entry("search without search terms", `search(" ")`),
+			Entry("search as a member function", `a.search("b")`),
+			Entry("search inside nestedFilter", `a.nestedFilter(search("b"))`),
 		)
 	})
 })
@@ -8,10 +8,11 @@ import (
 )
 
 type FakeFilterer struct {
-	ParseExpressionStub        func(string) (*filtering.Query, error)
+	ParseExpressionStub        func(string, string) (*filtering.Query, error)
 	parseExpressionMutex       sync.RWMutex
 	parseExpressionArgsForCall []struct {
 		arg1 string
+		arg2 string
 	}
 	parseExpressionReturns struct {
 		result1 *filtering.Query
@@ -25,18 +26,19 @@ type FakeFilterer struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeFilterer) ParseExpression(arg1 string) (*filtering.Query, error) {
+func (fake *FakeFilterer) ParseExpression(arg1 string, arg2 string) (*filtering.Query, error) {
 	fake.parseExpressionMutex.Lock()
 	ret, specificReturn := fake.parseExpressionReturnsOnCall[len(fake.parseExpressionArgsForCall)]
 	fake.parseExpressionArgsForCall = append(fake.parseExpressionArgsForCall, struct {
 		arg1 string
-	}{arg1})
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.ParseExpressionStub
 	fakeReturns := fake.parseExpressionReturns
-	fake.recordInvocation("ParseExpression", []interface{}{arg1})
+	fake.recordInvocation("ParseExpression", []interface{}{arg1, arg2})
 	fake.parseExpressionMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -50,17 +52,17 @@ func (fake *FakeFilterer) ParseExpressionCallCount() int {
 	return len(fake.parseExpressionArgsForCall)
 }
 
-func (fake *FakeFilterer) ParseExpressionCalls(stub func(string) (*filtering.Query, error)) {
+func (fake *FakeFilterer) ParseExpressionCalls(stub func(string, string) (*filtering.Query, error)) {
 	fake.parseExpressionMutex.Lock()
 	defer fake.parseExpressionMutex.Unlock()
 	fake.ParseExpressionStub = stub
 }
 
-func (fake *FakeFilterer) ParseExpressionArgsForCall(i int) string {
+func (fake *FakeFilterer) ParseExpressionArgsForCall(i int) (string, string) {
 	fake.parseExpressionMutex.RLock()
 	defer fake.parseExpressionMutex.RUnlock()
 	argsForCall := fake.parseExpressionArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeFilterer) ParseExpressionReturns(result1 *filtering.Query, result2 error) {
@@ -0,0 +1,138 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"strings"
+
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/overloads"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// limits counts the clauses and wildcard terms in a filter, so that expensive filters can be rejected before they're translated
+type limits struct {
+	config        *config.FilterConfig
+	clauses       int
+	wildcardTerms int
+}
+
+// checkLimits returns an error if the expression exceeds any of the configured filter limits
+func checkLimits(c *config.FilterConfig, expression *expr.Expr) error {
+	l := &limits{config: c}
+
+	return l.check(expression, 1)
+}
+
+func (l *limits) check(expression *expr.Expr, depth int) error {
+	if maxDepth := l.config.Depth(); depth > maxDepth {
+		return newExprError(expression, "filter is nested deeper than the maximum depth of %d", maxDepth)
+	}
+
+	var children []*expr.Expr
+	switch e := expression.ExprKind.(type) {
+	case *expr.Expr_SelectExpr:
+		// has(a.b) is expanded into a test-only select expression
+		if e.SelectExpr.TestOnly {
+			if err := l.addClauses(expression, 1); err != nil {
+				return err
+			}
+		}
+		children = []*expr.Expr{e.SelectExpr.Operand}
+	case *expr.Expr_ListExpr:
+		children = e.ListExpr.Elements
+	case *expr.Expr_CallExpr:
+		if err := l.checkCall(expression); err != nil {
+			return err
+		}
+		if e.CallExpr.Target != nil {
+			children = append(children, e.CallExpr.Target)
+		}
+		children = append(children, e.CallExpr.Args...)
+	}
+
+	for _, child := range children {
+		if err := l.check(child, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *limits) checkCall(expression *expr.Expr) error {
+	callExpr := expression.GetCallExpr()
+
+	switch callExpr.Function {
+	case operators.Equals,
+		operators.NotEquals,
+		operators.Greater,
+		operators.GreaterEquals,
+		operators.Less,
+		operators.LessEquals,
+		overloads.StartsWith,
+		search:
+		return l.addClauses(expression, 1)
+	case operators.In:
+		// each value in the list is a separate term
+		clauses := 1
+		if len(callExpr.Args) == 2 && len(callExpr.Args[1].GetListExpr().GetElements()) > 0 {
+			clauses = len(callExpr.Args[1].GetListExpr().GetElements())
+		}
+
+		return l.addClauses(expression, clauses)
+	case overloads.Contains,
+		overloads.EndsWith,
+		overloads.Matches:
+		if err := l.addClauses(expression, 1); err != nil {
+			return err
+		}
+
+		l.wildcardTerms++
+		if maxWildcardTerms := l.config.WildcardTerms(); l.wildcardTerms > maxWildcardTerms {
+			return newExprError(expression, "filter has more than the maximum of %d contains, endsWith, and matches functions", maxWildcardTerms)
+		}
+
+		if l.config.DisallowLeadingWildcards && hasLeadingWildcard(callExpr) {
+			return newExprError(expression, "%s is not allowed, because it requires a leading wildcard", callExpr.Function)
+		}
+	}
+
+	return nil
+}
+
+func (l *limits) addClauses(expression *expr.Expr, clauses int) error {
+	l.clauses += clauses
+	if maxClauses := l.config.Clauses(); l.clauses > maxClauses {
+		return newExprError(expression, "filter has more than the maximum of %d clauses", maxClauses)
+	}
+
+	return nil
+}
+
+// hasLeadingWildcard returns true if the function is translated into a query that starts with a wildcard.
+// Only patterns anchored with ^ can be matched without one.
+func hasLeadingWildcard(callExpr *expr.Expr_Call) bool {
+	if callExpr.Function != overloads.Matches {
+		return true
+	}
+
+	if len(callExpr.Args) != 1 {
+		return false
+	}
+
+	return !strings.HasPrefix(callExpr.Args[0].GetConstExpr().GetStringValue(), "^")
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/common/operators"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Schemas maps a document kind to the protobuf message stored in documents of that kind.
+// Filters for a document kind with a schema are validated against the message's JSON field names and types.
+type Schemas map[string]protoreflect.MessageDescriptor
+
+// well-known types that are serialized as JSON scalars, or contain arbitrary JSON
+const (
+	timestampMessage = "google.protobuf.Timestamp"
+	durationMessage  = "google.protobuf.Duration"
+	anyMessage       = "google.protobuf.Any"
+	structMessage    = "google.protobuf.Struct"
+	valueMessage     = "google.protobuf.Value"
+	listValueMessage = "google.protobuf.ListValue"
+)
+
+// exprError is an error caused by a specific expression, so that it can be reported along with its location in the filter
+type exprError struct {
+	id  int64
+	err error
+}
+
+func (e *exprError) Error() string {
+	return e.err.Error()
+}
+
+func newExprError(expression *expr.Expr, format string, args ...interface{}) error {
+	return &exprError{
+		id:  expression.Id,
+		err: fmt.Errorf(format, args...),
+	}
+}
+
+// resolveField finds the descriptor for the field at the given path.
+// A nil descriptor without an error means the field is inside a message that holds arbitrary JSON, so it can't be checked.
+func resolveField(schema protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	message := schema
+	var field protoreflect.FieldDescriptor
+
+	segments := strings.Split(path, ".")
+	for i := 0; i < len(segments); i++ {
+		if message == nil {
+			return nil, fmt.Errorf("field %q does not exist: %q is not a message", path, strings.Join(segments[:i], "."))
+		}
+
+		if isDynamicMessage(message) {
+			return nil, nil
+		}
+
+		field = message.Fields().ByJSONName(segments[i])
+		if field == nil {
+			return nil, fmt.Errorf("field %q does not exist on %s", path, message.FullName())
+		}
+
+		// the next segment is a map key, so skip ahead to the map's values
+		if field.IsMap() {
+			i++
+			field = field.MapValue()
+		}
+
+		message = nil
+		if field.Kind() == protoreflect.MessageKind && !isScalarMessage(field.Message()) {
+			message = field.Message()
+		}
+	}
+
+	return field, nil
+}
+
+// zeroValue returns the value that a field without presence is serialized as when it isn't set.
+// Fields with presence, lists and maps are left out of documents, or are null or empty, when they aren't set.
+func zeroValue(field protoreflect.FieldDescriptor) (interface{}, bool) {
+	if field.HasPresence() || field.IsList() || field.IsMap() || field.ContainingMessage().IsMapEntry() {
+		return nil, false
+	}
+
+	switch field.Kind() {
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		return "", true
+	case protoreflect.BoolKind:
+		return false, true
+	case protoreflect.EnumKind:
+		return string(field.Enum().Values().ByNumber(0).Name()), true
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// 64-bit integers are serialized as JSON strings
+		return "0", true
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return nil, false
+	}
+
+	return 0, true
+}
+
+// checkOperator returns an error if the comparison can't be made against the field.
+// 64-bit integers are serialized as JSON strings and indexed as keywords, so ranges would compare them as text, e.g. "10" < "9".
+func checkOperator(field protoreflect.FieldDescriptor, function string) error {
+	switch function {
+	case operators.Greater, operators.GreaterEquals, operators.Less, operators.LessEquals:
+	default:
+		return nil
+	}
+
+	switch field.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		operator, _ := operators.FindReverse(function)
+		return fmt.Errorf("field %s is a 64-bit integer, which is stored as a string, so it can't be compared with %s", field.JSONName(), operator)
+	}
+
+	return nil
+}
+
+// checkValue returns an error if the value can't be compared against the field
+func checkValue(field protoreflect.FieldDescriptor, value interface{}) error {
+	switch field.Kind() {
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if _, ok := value.(string); ok {
+			return nil
+		}
+	case protoreflect.BoolKind:
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+	case protoreflect.EnumKind:
+		if name, ok := value.(string); ok {
+			if field.Enum().Values().ByName(protoreflect.Name(name)) == nil {
+				return fmt.Errorf("%q is not a valid value for %s", name, field.Enum().FullName())
+			}
+
+			return nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		switch value.(type) {
+		case int64, uint64:
+			return nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// 64-bit integers are serialized as JSON strings
+		switch value.(type) {
+		case int64, uint64, string:
+			return nil
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		switch value.(type) {
+		case int64, uint64, float64:
+			return nil
+		}
+	case protoreflect.MessageKind:
+		if isDynamicMessage(field.Message()) {
+			return nil
+		}
+
+		if isScalarMessage(field.Message()) {
+			if _, ok := value.(string); ok {
+				return nil
+			}
+		} else {
+			return fmt.Errorf("field %s is a message and can't be compared to a value", field.JSONName())
+		}
+	}
+
+	return fmt.Errorf("field %[1]s has type %[2]s, but %[3]v has type %[3]T", field.JSONName(), fieldTypeName(field), value)
+}
+
+func fieldTypeName(field protoreflect.FieldDescriptor) string {
+	switch field.Kind() {
+	case protoreflect.EnumKind:
+		return string(field.Enum().FullName())
+	case protoreflect.MessageKind:
+		return string(field.Message().FullName())
+	}
+
+	return field.Kind().String()
+}
+
+// isScalarMessage returns true for well-known types that are serialized as JSON strings
+func isScalarMessage(message protoreflect.MessageDescriptor) bool {
+	switch message.FullName() {
+	case timestampMessage, durationMessage:
+		return true
+	}
+
+	return false
+}
+
+// isDynamicMessage returns true for well-known types that contain arbitrary JSON
+func isDynamicMessage(message protoreflect.MessageDescriptor) bool {
+	switch message.FullName() {
+	case anyMessage, structMessage, valueMessage, listValueMessage:
+		return true
+	}
+
+	return false
+}
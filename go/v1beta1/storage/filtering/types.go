@@ -16,13 +16,39 @@ package filtering
 
 // Query holds a parent query that carries the entire search query
 type Query struct {
-	Bool        *Bool        `json:"bool,omitempty"`
-	Term        *Term        `json:"term,omitempty"`
-	Prefix      *Term        `json:"prefix,omitempty"`
-	QueryString *QueryString `json:"query_string,omitempty"`
-	Nested      *Nested      `json:"nested,omitempty"`
-	Range       *Range       `json:"range,omitempty"`
-	HasParent   *HasParent   `json:"has_parent,omitempty"`
+	Bool              *Bool              `json:"bool,omitempty"`
+	Term              *Term              `json:"term,omitempty"`
+	Terms             *Terms             `json:"terms,omitempty"`
+	Prefix            *Term              `json:"prefix,omitempty"`
+	Wildcard          *Term              `json:"wildcard,omitempty"`
+	Regexp            *Regexp            `json:"regexp,omitempty"`
+	QueryString       *QueryString       `json:"query_string,omitempty"`
+	SimpleQueryString *SimpleQueryString `json:"simple_query_string,omitempty"`
+	Nested            *Nested            `json:"nested,omitempty"`
+	Range             *Range             `json:"range,omitempty"`
+	HasParent         *HasParent         `json:"has_parent,omitempty"`
+	Exists            *Exists            `json:"exists,omitempty"`
+}
+
+// IsFullTextSearch returns true if the query or any of its clauses is a full-text search, so results should be ordered by relevance
+func (q *Query) IsFullTextSearch() bool {
+	if q == nil {
+		return false
+	}
+
+	if q.SimpleQueryString != nil {
+		return true
+	}
+
+	if q.Nested != nil && q.Nested.Query.IsFullTextSearch() {
+		return true
+	}
+
+	if q.HasParent != nil && q.HasParent.Query.IsFullTextSearch() {
+		return true
+	}
+
+	return q.Bool.isFullTextSearch()
 }
 
 // Bool holds a general query that carries any number of
@@ -34,6 +60,35 @@ type Bool struct {
 	Term    *Term    `json:"term,omitempty"`
 }
 
+func (b *Bool) isFullTextSearch() bool {
+	if b == nil {
+		return false
+	}
+
+	var clauses []interface{}
+	if b.Must != nil {
+		clauses = append(clauses, *b.Must...)
+	}
+	if b.Should != nil {
+		clauses = append(clauses, *b.Should...)
+	}
+
+	for _, clause := range clauses {
+		switch c := clause.(type) {
+		case *Query:
+			if c.IsFullTextSearch() {
+				return true
+			}
+		case *Bool:
+			if c.isFullTextSearch() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // Must holds a must operator which each equates to an AND operation
 type Must []interface{}
 
@@ -43,14 +98,33 @@ type MustNot []interface{}
 // Should holds a should operator which equates to an OR operation
 type Should []interface{}
 
-// Term holds a comparison for equating two strings
-type Term map[string]string
+// Term holds a comparison for equating a field with a value.
+// Values should be one of bool, int64, uint64, float64, or string so that they're serialized as the matching JSON type.
+type Term map[string]interface{}
+
+// Terms holds a comparison for matching a field against any of the given values
+type Terms map[string][]interface{}
+
+// Regexp holds a regular expression to match against a field
+type Regexp map[string]*RegexpOptions
+
+type RegexpOptions struct {
+	Value                 string `json:"value"`
+	Flags                 string `json:"flags,omitempty"`
+	MaxDeterminizedStates int    `json:"max_determinized_states,omitempty"`
+}
 
 type QueryString struct {
 	DefaultField string `json:"default_field"`
 	Query        string `json:"query"`
 }
 
+// SimpleQueryString is a full-text search of the given fields, using the simple query string syntax
+type SimpleQueryString struct {
+	Query  string   `json:"query"`
+	Fields []string `json:"fields,omitempty"`
+}
+
 type Nested struct {
 	Path  string `json:"path"`
 	Query *Query `json:"query,omitempty"`
@@ -59,15 +133,21 @@ type Nested struct {
 // Holds an operator that evaluates a range for comparisons
 type Range map[string]*RangeOperator
 
+// Exists matches documents that contain a value for the field
+type Exists struct {
+	Field string `json:"field"`
+}
+
 // HasParent is used to query for resources that use a join field and have a parent resource
 type HasParent struct {
 	ParentType string `json:"parent_type"`
 	Query      *Query `json:"query"`
 }
 
+// RangeOperator holds the bounds of a range. As with Term, bounds are typed so that numbers are compared numerically.
 type RangeOperator struct {
-	Greater       string `json:"gt,omitempty"`
-	GreaterEquals string `json:"gte,omitempty"`
-	Less          string `json:"lt,omitempty"`
-	LessEquals    string `json:"lte,omitempty"`
+	Greater       interface{} `json:"gt,omitempty"`
+	GreaterEquals interface{} `json:"gte,omitempty"`
+	Less          interface{} `json:"lt,omitempty"`
+	LessEquals    interface{} `json:"lte,omitempty"`
 }
@@ -0,0 +1,160 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/rode/es-index-manager/indexmanager"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/mappings"
+	"go.uber.org/zap"
+)
+
+type indexManager struct {
+	indexmanager.MappingsRegistry
+	indexmanager.IndexRepository
+	indexmanager.MigrationOrchestrator
+}
+
+// NewIndexManager creates an IndexManager that names indices with the configured prefix, using the mappings in
+// MappingsPath, and the mappings built into Grafeas for any document kinds that aren't in it
+func NewIndexManager(logger *zap.Logger, client *elasticsearch.Client, c *config.ElasticsearchConfig) indexmanager.IndexManager {
+	imConfig := &indexmanager.Config{
+		IndexPrefix:  c.Prefix(),
+		MappingsPath: ".",
+		Migration: &indexmanager.MigrationConfig{
+			PollAttempts: 10,
+			PollInterval: time.Second * 10,
+		},
+	}
+
+	var mappingsFS fs.FS = mappings.FS
+	source := "built-in mappings"
+	if c.MappingsPath != "" {
+		mappingsFS = &overlayFS{os.DirFS(c.MappingsPath), mappings.FS}
+		source = c.MappingsPath
+	}
+
+	registry := &mappingsRegistry{
+		indexmanager.NewMappingsRegistry(imConfig, mappingsFS),
+		c.Prefix(),
+		source,
+	}
+	repo := indexmanager.NewIndexRepository(logger, client, registry)
+	orchestrator := indexmanager.NewMigrationOrchestrator(logger, indexmanager.NewMigrator(logger, client, registry, repo, time.Sleep, imConfig))
+
+	return &indexManager{
+		registry,
+		repo,
+		orchestrator,
+	}
+}
+
+func (im *indexManager) Initialize(ctx context.Context) error {
+	if err := im.LoadMappings(); err != nil {
+		return fmt.Errorf("error occurred loading index mappings: %s", err)
+	}
+
+	if err := im.RunMigrations(ctx); err != nil {
+		return fmt.Errorf("error running migrations: %s", err)
+	}
+
+	return nil
+}
+
+// mappingsRegistry checks that there's a mapping for each document kind, and sets their _meta.type to the index prefix.
+// The index manager only migrates indices with its prefix and type, so deployments with different prefixes leave each other's indices alone.
+type mappingsRegistry struct {
+	indexmanager.MappingsRegistry
+	prefix string
+	source string
+}
+
+func (r *mappingsRegistry) LoadMappings() error {
+	if err := r.MappingsRegistry.LoadMappings(); err != nil {
+		return fmt.Errorf("%s: %s", r.source, err)
+	}
+
+	for _, documentKind := range []string{projectDocumentKind, occurrencesDocumentKind, notesDocumentKind} {
+		mapping := r.Mapping(documentKind)
+		if mapping == nil {
+			return fmt.Errorf("%s: missing mapping for %s", r.source, documentKind)
+		}
+
+		if mapping.Mappings == nil {
+			mapping.Mappings = map[string]interface{}{}
+		}
+
+		meta, ok := mapping.Mappings["_meta"].(map[string]interface{})
+		if !ok {
+			meta = map[string]interface{}{}
+			mapping.Mappings["_meta"] = meta
+		}
+		meta["type"] = r.prefix
+	}
+
+	return nil
+}
+
+// overlayFS reads files from upper, falling back to lower for files that upper doesn't have.
+// Directory listings include the files from both.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	file, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+
+	return file, err
+}
+
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(o.upper, name)
+	if err != nil {
+		return nil, err
+	}
+
+	lowerEntries, err := fs.ReadDir(o.lower, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	upperNames := map[string]bool{}
+	for _, entry := range entries {
+		upperNames[entry.Name()] = true
+	}
+	for _, entry := range lowerEntries {
+		if !upperNames[entry.Name()] {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
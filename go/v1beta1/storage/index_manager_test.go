@@ -0,0 +1,233 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rode/es-index-manager/indexmanager"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/mappings"
+)
+
+var _ = Describe("NewIndexManager", func() {
+	var (
+		esConfig *config.ElasticsearchConfig
+		manager  indexmanager.IndexManager
+
+		actualErr error
+	)
+
+	documentKinds := []string{projectDocumentKind, occurrencesDocumentKind, notesDocumentKind}
+
+	builtInMapping := func(documentKind string) *indexmanager.VersionedMapping {
+		contents, err := mappings.FS.ReadFile(documentKind + ".json")
+		Expect(err).ToNot(HaveOccurred())
+
+		mapping := &indexmanager.VersionedMapping{}
+		Expect(json.Unmarshal(contents, mapping)).To(Succeed())
+
+		return mapping
+	}
+
+	metaType := func(documentKind string) interface{} {
+		return manager.Mapping(documentKind).Mappings["_meta"].(map[string]interface{})["type"]
+	}
+
+	BeforeEach(func() {
+		esConfig = &config.ElasticsearchConfig{}
+	})
+
+	JustBeforeEach(func() {
+		manager = NewIndexManager(logger, nil, esConfig)
+
+		actualErr = manager.LoadMappings()
+	})
+
+	When("the defaults are used", func() {
+		It("should load the built-in mappings", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			for _, documentKind := range documentKinds {
+				Expect(manager.Mapping(documentKind)).To(Equal(builtInMapping(documentKind)))
+			}
+		})
+
+		It("should map CVSS scores as floats, so that integral scores aren't dynamically mapped as longs", func() {
+			fieldType := func(documentKind string, path ...string) interface{} {
+				mapping := manager.Mapping(documentKind).Mappings
+				for _, field := range path {
+					mapping = mapping["properties"].(map[string]interface{})[field].(map[string]interface{})
+				}
+
+				return mapping["type"]
+			}
+
+			Expect(fieldType(occurrencesDocumentKind, "vulnerability", "cvssScore")).To(Equal("float"))
+			Expect(fieldType(notesDocumentKind, "vulnerability", "cvssScore")).To(Equal("float"))
+			for _, score := range []string{"baseScore", "exploitabilityScore", "impactScore"} {
+				Expect(fieldType(notesDocumentKind, "vulnerability", "cvssV3", score)).To(Equal("float"))
+			}
+		})
+
+		It("should name indices and aliases with the default prefix", func() {
+			version := builtInMapping(projectDocumentKind).Version
+
+			Expect(manager.IndexName(projectDocumentKind, "")).To(Equal("grafeas-" + version + "-projects"))
+			Expect(manager.AliasName(projectDocumentKind, "")).To(Equal("grafeas-projects"))
+		})
+	})
+
+	When("an index prefix is set", func() {
+		var prefix string
+
+		BeforeEach(func() {
+			prefix = "grafeas_" + fake.Word()
+			esConfig.IndexPrefix = prefix
+		})
+
+		It("should name indices and aliases with the prefix", func() {
+			projectId := fake.LetterN(10)
+			version := builtInMapping(occurrencesDocumentKind).Version
+
+			Expect(manager.IndexName(occurrencesDocumentKind, projectId)).To(Equal(prefix + "-" + version + "-" + projectId + "-occurrences"))
+			Expect(manager.AliasName(occurrencesDocumentKind, projectId)).To(Equal(prefix + "-" + projectId + "-occurrences"))
+		})
+
+		It("should set the mappings' type to the prefix", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			for _, documentKind := range documentKinds {
+				Expect(metaType(documentKind)).To(Equal(prefix))
+			}
+		})
+
+		It("should be able to parse its own index names", func() {
+			indexName := manager.IndexName(notesDocumentKind, "foo")
+
+			Expect(manager.ParseIndexName(indexName)).To(Equal(&indexmanager.IndexName{
+				DocumentKind: notesDocumentKind,
+				Version:      builtInMapping(notesDocumentKind).Version,
+				Inner:        "foo",
+			}))
+		})
+	})
+
+	When("a mappings path is set", func() {
+		var (
+			mappingsPath string
+			version      string
+		)
+
+		writeMapping := func(documentKind string, mapping map[string]interface{}) {
+			contents, err := json.Marshal(&indexmanager.VersionedMapping{
+				Version:  version,
+				Mappings: mapping,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(mappingsPath, documentKind+".json"), contents, 0600)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			mappingsPath, err = os.MkdirTemp("", "mappings")
+			Expect(err).ToNot(HaveOccurred())
+
+			version = fake.LetterN(5)
+			esConfig.MappingsPath = mappingsPath
+			esConfig.IndexPrefix = "grafeas_qa"
+
+			writeMapping(projectDocumentKind, map[string]interface{}{
+				"_meta": map[string]interface{}{
+					"type":  "grafeas",
+					"owner": "qa",
+				},
+			})
+			writeMapping(occurrencesDocumentKind, map[string]interface{}{})
+			writeMapping(notesDocumentKind, nil)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(mappingsPath)).To(Succeed())
+		})
+
+		It("should load the mappings from the directory", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			for _, documentKind := range documentKinds {
+				Expect(manager.Version(documentKind)).To(Equal(version))
+			}
+		})
+
+		It("should set the mappings' type to the prefix", func() {
+			for _, documentKind := range documentKinds {
+				Expect(metaType(documentKind)).To(Equal("grafeas_qa"))
+			}
+		})
+
+		It("should keep the rest of the mappings' metadata", func() {
+			Expect(manager.Mapping(projectDocumentKind).Mappings["_meta"]).To(HaveKeyWithValue("owner", "qa"))
+		})
+
+		When("a document kind is missing", func() {
+			BeforeEach(func() {
+				Expect(os.Remove(filepath.Join(mappingsPath, notesDocumentKind+".json"))).To(Succeed())
+			})
+
+			It("should use the built-in mapping for it", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				Expect(manager.Version(notesDocumentKind)).To(Equal(builtInMapping(notesDocumentKind).Version))
+				Expect(manager.Mapping(notesDocumentKind).Mappings["properties"]).To(Equal(builtInMapping(notesDocumentKind).Mappings["properties"]))
+				Expect(metaType(notesDocumentKind)).To(Equal("grafeas_qa"))
+			})
+
+			It("should load the other document kinds from the directory", func() {
+				Expect(manager.Version(projectDocumentKind)).To(Equal(version))
+				Expect(manager.Version(occurrencesDocumentKind)).To(Equal(version))
+			})
+		})
+
+		When("the directory only overrides one document kind", func() {
+			BeforeEach(func() {
+				Expect(os.Remove(filepath.Join(mappingsPath, projectDocumentKind+".json"))).To(Succeed())
+				Expect(os.Remove(filepath.Join(mappingsPath, notesDocumentKind+".json"))).To(Succeed())
+			})
+
+			It("should use the built-in mappings for the rest", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				Expect(manager.Version(occurrencesDocumentKind)).To(Equal(version))
+				Expect(manager.Version(projectDocumentKind)).To(Equal(builtInMapping(projectDocumentKind).Version))
+				Expect(manager.Version(notesDocumentKind)).To(Equal(builtInMapping(notesDocumentKind).Version))
+			})
+		})
+
+		When("the directory doesn't exist", func() {
+			BeforeEach(func() {
+				esConfig.MappingsPath = filepath.Join(mappingsPath, "missing")
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(actualErr).To(MatchError(ContainSubstring(esConfig.MappingsPath)))
+			})
+		})
+	})
+})
@@ -0,0 +1,24 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mappings holds the Elasticsearch index mappings for each document kind, built into the binary so that
+// Grafeas doesn't need them on disk.
+package mappings
+
+import "embed"
+
+// FS contains a <document kind>.json file for each document kind, at its root
+//
+//go:embed *.json
+var FS embed.FS
@@ -26,6 +26,7 @@ import (
 	"github.com/rode/grafeas-elasticsearch/test/util"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"strings"
 	"testing"
 )
@@ -151,6 +152,7 @@ func TestNote(t *testing.T) {
 		// ensure notes have something in common to filter against
 		buildNote.ShortDescription = vulnerabilityNote.ShortDescription
 		vulnerabilityNote.LongDescription = attestationNote.LongDescription
+		secondBuildNote.LongDescription = "Remote code execution in Log4j"
 
 		// create
 		batch, err := s.Gc.BatchCreateNotes(s.Ctx, &grafeas_go_proto.BatchCreateNotesRequest{
@@ -230,6 +232,13 @@ func TestNote(t *testing.T) {
 						secondVulnerabilityNote,
 					},
 				},
+				{
+					name:   "full-text search of descriptions",
+					filter: `search("log4j remote")`,
+					expected: []*grafeas_go_proto.Note{
+						secondBuildNote,
+					},
+				},
 			} {
 				// ensure parallel tests are run with correct test case
 				tc := tc
@@ -289,6 +298,116 @@ func TestNote(t *testing.T) {
 		})
 	})
 
+	t.Run("updating a note", func(t *testing.T) {
+		n, err := s.Gc.CreateNote(s.Ctx, &grafeas_go_proto.CreateNoteRequest{
+			Parent: projectName,
+			NoteId: fake.UUID(),
+			Note:   createFakeBuildNote(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		expectedDescription := fake.LoremIpsumSentence(fake.Number(5, 10))
+
+		_, err = s.Gc.UpdateNote(s.Ctx, &grafeas_go_proto.UpdateNoteRequest{
+			Name: n.GetName(),
+			Note: &grafeas_go_proto.Note{
+				ShortDescription: expectedDescription,
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{
+				Paths: []string{"ShortDescription"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		updatedNote, err := s.Gc.GetNote(s.Ctx, &grafeas_go_proto.GetNoteRequest{Name: n.GetName()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updatedNote.ShortDescription).To(Equal(expectedDescription))
+		Expect(updatedNote.LongDescription).To(Equal(n.LongDescription))
+		Expect(updatedNote.UpdateTime).ToNot(Equal(n.UpdateTime))
+	})
+
+	t.Run("listing note occurrences", func(t *testing.T) {
+		n, err := s.Gc.CreateNote(s.Ctx, &grafeas_go_proto.CreateNoteRequest{
+			Parent: projectName,
+			NoteId: fake.UUID(),
+			Note:   createFakeBuildNote(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		// occurrences for the note are spread across several projects
+		var expectedOccurrenceNames []string
+		for i := 0; i < 3; i++ {
+			occurrenceProjectName := util.RandomProjectName()
+			_, err := util.CreateProject(s, occurrenceProjectName)
+			Expect(err).ToNot(HaveOccurred())
+
+			occurrence := createFakeBuildOccurrence(occurrenceProjectName)
+			occurrence.NoteName = n.GetName()
+
+			o, err := s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
+				Parent:     occurrenceProjectName,
+				Occurrence: occurrence,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedOccurrenceNames = append(expectedOccurrenceNames, o.GetName())
+
+			// this occurrence references a different note, and should never be returned
+			_, err = s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
+				Parent:     occurrenceProjectName,
+				Occurrence: createFakeBuildOccurrence(projectName),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		t.Run("should return occurrences from every project", func(t *testing.T) {
+			res, err := s.Gc.ListNoteOccurrences(s.Ctx, &grafeas_go_proto.ListNoteOccurrencesRequest{
+				Name: n.GetName(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			var actualOccurrenceNames []string
+			for _, o := range res.Occurrences {
+				actualOccurrenceNames = append(actualOccurrenceNames, o.GetName())
+			}
+			Expect(actualOccurrenceNames).To(ConsistOf(expectedOccurrenceNames))
+		})
+
+		t.Run("should apply the filter", func(t *testing.T) {
+			res, err := s.Gc.ListNoteOccurrences(s.Ctx, &grafeas_go_proto.ListNoteOccurrencesRequest{
+				Name:   n.GetName(),
+				Filter: fmt.Sprintf(`name=="%s"`, expectedOccurrenceNames[0]),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Occurrences).To(HaveLen(1))
+			Expect(res.Occurrences[0].GetName()).To(Equal(expectedOccurrenceNames[0]))
+		})
+
+		t.Run("should use pagination", func(t *testing.T) {
+			var (
+				foundOccurrences []*grafeas_go_proto.Occurrence
+				pageToken        string
+			)
+
+			for {
+				res, err := s.Gc.ListNoteOccurrences(s.Ctx, &grafeas_go_proto.ListNoteOccurrencesRequest{
+					Name:      n.GetName(),
+					PageSize:  1,
+					PageToken: pageToken,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				foundOccurrences = append(foundOccurrences, res.Occurrences...)
+				if res.NextPageToken == "" {
+					break
+				}
+				pageToken = res.NextPageToken
+			}
+
+			Expect(foundOccurrences).To(HaveLen(len(expectedOccurrenceNames)))
+		})
+	})
+
 	t.Run("deleting a note", func(t *testing.T) {
 		noteId := fake.UUID()
 
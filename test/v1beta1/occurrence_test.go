@@ -31,6 +31,7 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
 	"testing"
 	"time"
 )
@@ -138,6 +139,118 @@ func TestOccurrence(t *testing.T) {
 		Expect(newlyUpdatedOccurrence.UpdateTime).ToNot(Equal(o.UpdateTime))
 	})
 
+	t.Run("getting an occurrence's note", func(t *testing.T) {
+		// notes commonly live in a separate provider project from the occurrences that reference them
+		noteProjectName := util.RandomProjectName()
+		_, err := util.CreateProject(s, noteProjectName)
+		Expect(err).ToNot(HaveOccurred())
+
+		noteName := util.RandomNoteName(noteProjectName)
+		n, err := s.Gc.CreateNote(s.Ctx, &grafeas_go_proto.CreateNoteRequest{
+			Parent: noteProjectName,
+			NoteId: strings.Split(noteName, "/")[3],
+			Note:   createFakeBuildNote(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		occurrence := createFakeBuildOccurrence(projectName)
+		occurrence.NoteName = n.GetName()
+
+		t.Run("should return the note from another project", func(t *testing.T) {
+			o, err := s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
+				Parent:     projectName,
+				Occurrence: occurrence,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			actualNote, err := s.Gc.GetOccurrenceNote(s.Ctx, &grafeas_go_proto.GetOccurrenceNoteRequest{Name: o.GetName()})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actualNote).To(Equal(n))
+		})
+
+		t.Run("should return not found if the note does not exist", func(t *testing.T) {
+			o, err := s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
+				Parent:     projectName,
+				Occurrence: createFakeBuildOccurrence(noteProjectName),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = s.Gc.GetOccurrenceNote(s.Ctx, &grafeas_go_proto.GetOccurrenceNoteRequest{Name: o.GetName()})
+			Expect(err).To(HaveOccurred())
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+		})
+
+		t.Run("should return not found if the occurrence does not exist", func(t *testing.T) {
+			_, err := s.Gc.GetOccurrenceNote(s.Ctx, &grafeas_go_proto.GetOccurrenceNoteRequest{
+				Name: fmt.Sprintf("%s/occurrences/%s", projectName, fake.UUID()),
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+		})
+	})
+
+	t.Run("summarizing vulnerability occurrences", func(t *testing.T) {
+		summaryProjectName := util.RandomProjectName()
+		_, err := util.CreateProject(s, summaryProjectName)
+		Expect(err).ToNot(HaveOccurred())
+
+		resourceUri := fake.URL()
+		severities := []vulnerability_go_proto.Severity{
+			vulnerability_go_proto.Severity_CRITICAL,
+			vulnerability_go_proto.Severity_CRITICAL,
+			vulnerability_go_proto.Severity_LOW,
+		}
+		for i, severity := range severities {
+			occurrence := createFakeVulnerabilityOccurrence(summaryProjectName)
+			occurrence.Resource.Uri = resourceUri
+			vulnerability := occurrence.GetVulnerability()
+			vulnerability.Severity = severity
+			// only the first occurrence has a fix available
+			if i == 0 {
+				vulnerability.PackageIssue[0].FixedLocation = &vulnerability_go_proto.VulnerabilityLocation{
+					CpeUri:  fake.URL(),
+					Package: fake.AppName(),
+					Version: &package_go_proto.Version{
+						Name: fake.AppVersion(),
+						Kind: package_go_proto.Version_NORMAL,
+					},
+				}
+			}
+
+			_, err := s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
+				Parent:     summaryProjectName,
+				Occurrence: occurrence,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		// occurrences of other kinds are not counted
+		_, err = s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
+			Parent:     summaryProjectName,
+			Occurrence: createFakeBuildOccurrence(summaryProjectName),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		summary, err := s.Gc.GetVulnerabilityOccurrencesSummary(s.Ctx, &grafeas_go_proto.GetVulnerabilityOccurrencesSummaryRequest{
+			Parent: summaryProjectName,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		counts := map[vulnerability_go_proto.Severity]*grafeas_go_proto.VulnerabilityOccurrencesSummary_FixableTotalByDigest{}
+		for _, count := range summary.Counts {
+			Expect(count.Resource.Uri).To(Equal(resourceUri))
+			counts[count.Severity] = count
+		}
+
+		Expect(counts).To(HaveLen(3))
+		Expect(counts[vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED].TotalCount).To(BeEquivalentTo(3))
+		Expect(counts[vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED].FixableCount).To(BeEquivalentTo(1))
+		Expect(counts[vulnerability_go_proto.Severity_CRITICAL].TotalCount).To(BeEquivalentTo(2))
+		Expect(counts[vulnerability_go_proto.Severity_CRITICAL].FixableCount).To(BeEquivalentTo(1))
+		Expect(counts[vulnerability_go_proto.Severity_LOW].TotalCount).To(BeEquivalentTo(1))
+		Expect(counts[vulnerability_go_proto.Severity_LOW].FixableCount).To(BeEquivalentTo(0))
+	})
+
 	t.Run("deleting an occurrence", func(t *testing.T) {
 		o, err := s.Gc.CreateOccurrence(s.Ctx, &grafeas_go_proto.CreateOccurrenceRequest{
 			Parent:     projectName,